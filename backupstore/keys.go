@@ -0,0 +1,223 @@
+package backupstore
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"filippo.io/age"
+	"fmt"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	// PassphraseEnv is used when the configuration has no passphrase
+	PassphraseEnv = "MULTIPRESS_BACKUP_PASSPHRASE"
+	// IdentityEnv is the path of private keys of recipients, as generated by age-keygen
+	IdentityEnv = "MULTIPRESS_BACKUP_IDENTITY"
+
+	// KeyFile holds the key protected by the passphrase
+	KeyFile   = "key.age"
+	ageHeader = "age-encryption.org/v1\n"
+)
+
+var ErrNoIdentity = fmt.Errorf("backup is encrypted, set %s or %s", PassphraseEnv, IdentityEnv)
+
+// Keys encrypt new files to recipients and decrypt stored ones with identities, files being stored in plain text without recipients
+type Keys struct {
+	Recipients []age.Recipient
+	Identities []age.Identity
+	// recipients are public keys, identifying files encrypted to them
+	recipients []string
+	// nameKey keys names of files encrypted to recipients, so they do not reveal well-known contents
+	nameKey []byte
+}
+
+// fingerprint identifies recipients, files being stored again when they change
+func (k Keys) fingerprint() string {
+	if len(k.recipients) == 0 {
+		return ""
+	}
+	recipients := slices.Clone(k.recipients)
+	slices.Sort(recipients)
+	sum := sha256.Sum256([]byte(strings.Join(recipients, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// LoadKeys reads keys of the configuration, identityPath (MULTIPRESS_BACKUP_IDENTITY when empty) holding private keys of recipients
+func LoadKeys(root string, encryption *config.BackupEncryptionConfig, identityPath string) (Keys, error) {
+	var keys Keys
+
+	passphrase := os.Getenv(PassphraseEnv)
+	if encryption != nil {
+		if encryption.Passphrase != "" {
+			passphrase = encryption.Passphrase
+		}
+		for _, value := range encryption.Recipients {
+			recipient, err := age.ParseX25519Recipient(value)
+			if err != nil {
+				return keys, fmt.Errorf("invalid backup recipient %q: %w", value, err)
+			}
+			keys.Recipients = append(keys.Recipients, recipient)
+			keys.recipients = append(keys.recipients, recipient.String())
+		}
+	}
+
+	if passphrase != "" {
+		identity, err := storeKey(root, passphrase)
+		if err != nil {
+			return keys, err
+		}
+		keys.Recipients = append(keys.Recipients, identity.Recipient())
+		keys.recipients = append(keys.recipients, identity.Recipient().String())
+		keys.Identities = append(keys.Identities, identity)
+		keys.nameKey = deriveNameKey(identity)
+	}
+	if encryption != nil && encryption.NameKey != "" {
+		keys.nameKey = []byte(encryption.NameKey)
+	}
+
+	if identityPath == "" {
+		identityPath = os.Getenv(IdentityEnv)
+	}
+	if identityPath != "" {
+		file, err := os.Open(identityPath)
+		if err != nil {
+			return keys, fmt.Errorf("failed to read backup identity: %w", err)
+		}
+		defer file.Close()
+		identities, err := age.ParseIdentities(file)
+		if err != nil {
+			return keys, fmt.Errorf("invalid backup identity %s: %w", identityPath, err)
+		}
+		keys.Identities = append(keys.Identities, identities...)
+	}
+	return keys, nil
+}
+
+// storeKey returns the key of the store protected by the passphrase, generated on first use.
+// Files are encrypted to it, the costly passphrase derivation running once and not for each file.
+func storeKey(root string, passphrase string) (*age.X25519Identity, error) {
+	path := filepath.Join(root, KeyFile)
+	if utils.FileExists(path) {
+		identity, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		reader, err := age.Decrypt(file, identity)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unlock backup key %s, is the passphrase the one of the first encrypted backup? %w", path, err)
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+		return age.ParseX25519Identity(strings.TrimSpace(string(data)))
+	}
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		return nil, err
+	}
+	recipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	var sealed bytes.Buffer
+	writer, err := age.Encrypt(&sealed, recipient)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write([]byte(identity.String() + "\n")); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := utils.CreateDirectoryIfNotExists(root); err != nil {
+		return nil, err
+	}
+	return identity, utils.WriteFileAtomic(path, sealed.Bytes(), 0600)
+}
+
+// deriveNameKey keys names by the key of the store, sealed in KeyFile
+func deriveNameKey(identity *age.X25519Identity) []byte {
+	mac := hmac.New(sha256.New, []byte(identity.String()))
+	mac.Write([]byte("multipress object names"))
+	return mac.Sum(nil)
+}
+
+// Encrypts reports whether new files are encrypted
+func (s *Store) Encrypts() bool {
+	return len(s.keys.Recipients) > 0
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// encrypt returns a writer encrypting to recipients, as is without recipients, to close to flush it
+func (s *Store) encrypt(w io.Writer) (io.WriteCloser, error) {
+	if !s.Encrypts() {
+		return nopWriteCloser{w}, nil
+	}
+	return age.Encrypt(w, s.keys.Recipients...)
+}
+
+// decrypt returns r decrypted when encrypted, as is otherwise
+func (s *Store) decrypt(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	if !isEncrypted(buffered) {
+		return buffered, nil
+	}
+	if len(s.keys.Identities) == 0 {
+		return nil, ErrNoIdentity
+	}
+	reader, err := age.Decrypt(buffered, s.keys.Identities...)
+	var noMatch *age.NoIdentityMatchError
+	if errors.As(err, &noMatch) {
+		return nil, fmt.Errorf("backup is encrypted with another key: %w", err)
+	}
+	return reader, err
+}
+
+func isEncrypted(r *bufio.Reader) bool {
+	header, _ := r.Peek(len(ageHeader))
+	return string(header) == ageHeader
+}
+
+// Decrypt copies a file of the store into w, decrypted and uncompressed
+func (s *Store) Decrypt(r io.Reader, w io.Writer) error {
+	reader, err := s.decrypt(r)
+	if err != nil {
+		return err
+	}
+	gr, err := gzip.NewReader(reader)
+	if err != nil {
+		return fmt.Errorf("not a file of the store: %w", err)
+	}
+	defer gr.Close()
+	_, err = io.Copy(w, gr)
+	return err
+}
@@ -0,0 +1,132 @@
+package backupstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/quix-labs/multipress/config"
+	"io"
+	"os"
+	"time"
+)
+
+// Manifest lists entries of an instance backup, enough to recreate the instance once destroyed.
+// Only Contents are encrypted, sizes and objects remaining readable to prune the store without keys.
+type Manifest struct {
+	Identifier string    `json:"identifier"`
+	Date       time.Time `json:"date"`
+	// Size is the uncompressed size of files
+	Size int64 `json:"size"`
+	// Stored is the compressed size added to the store by this backup, other files being shared
+	Stored int64 `json:"stored"`
+	// Objects are names of stored files, hashes of their content keyed when encrypted
+	Objects []string `json:"objects,omitempty"`
+
+	Contents
+	// Sealed holds Contents encrypted with age, until unsealed
+	Sealed []byte `json:"sealed,omitempty"`
+}
+
+type Contents struct {
+	Credentials config.CredentialsConfig `json:"credentials"`
+	Metadata    config.InstanceMetadata  `json:"metadata"`
+	Entries     []Entry                  `json:"entries,omitempty"`
+}
+
+// Index returns entries by path, as previous entries of the next backup
+func (m *Manifest) Index() map[string]Entry {
+	index := make(map[string]Entry, len(m.Entries))
+	for _, entry := range m.Entries {
+		index[entry.Path] = entry
+	}
+	return index
+}
+
+// summarize fills the size and objects from entries
+func (m *Manifest) summarize() {
+	m.Size, m.Objects = 0, nil
+	seen := make(map[string]bool)
+	for _, entry := range m.Entries {
+		m.Size += entry.Size
+		if name := entry.ObjectName(); name != "" && !seen[name] {
+			seen[name] = true
+			m.Objects = append(m.Objects, name)
+		}
+	}
+}
+
+// ReadManifest reads a manifest, still sealed when encrypted
+func ReadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	manifest, err := ParseManifest(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", path, err)
+	}
+	return manifest, nil
+}
+
+// ParseManifest parses a manifest, e.g. read from a backup target
+func ParseManifest(data []byte) (*Manifest, error) {
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Sealed == nil && manifest.Objects == nil {
+		manifest.summarize() // Written before sizes and objects were recorded
+	}
+	return &manifest, nil
+}
+
+// WriteManifest writes the manifest, its contents being encrypted when the store encrypts
+func (s *Store) WriteManifest(path string, m *Manifest) error {
+	m.summarize()
+
+	written := *m
+	if s.Encrypts() {
+		contents, err := json.Marshal(m.Contents)
+		if err != nil {
+			return fmt.Errorf("failed to marshal manifest: %w", err)
+		}
+		var sealed bytes.Buffer
+		writer, err := s.encrypt(&sealed)
+		if err != nil {
+			return err
+		}
+		if _, err := writer.Write(contents); err != nil {
+			return err
+		}
+		if err := writer.Close(); err != nil {
+			return err
+		}
+		written.Contents, written.Sealed = Contents{}, sealed.Bytes()
+	}
+
+	data, err := json.Marshal(written)
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Unseal decrypts contents of the manifest, nothing being done when not encrypted
+func (s *Store) Unseal(m *Manifest) error {
+	if m.Sealed == nil {
+		return nil
+	}
+	reader, err := s.decrypt(bytes.NewReader(m.Sealed))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt manifest of %s: %w", m.Identifier, err)
+	}
+	contents, err := io.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt manifest of %s: %w", m.Identifier, err)
+	}
+	if err := json.Unmarshal(contents, &m.Contents); err != nil {
+		return fmt.Errorf("failed to parse manifest of %s: %w", m.Identifier, err)
+	}
+	m.Sealed = nil
+	return nil
+}
@@ -0,0 +1,66 @@
+package backupstore
+
+import (
+	"fmt"
+	"github.com/quix-labs/multipress/config"
+	"gopkg.in/yaml.v3"
+	"os"
+	"time"
+)
+
+// RunManifest records what a backup run holds, readable without keys to tell whether backups are intact.
+// Credentials and metadata of instances stay in their manifests, encrypted with them.
+type RunManifest struct {
+	// Version of multipress which made the backup
+	Version string    `yaml:"version"`
+	Date    time.Time `yaml:"date"`
+	// Config is the configuration at the time of the backup, secrets redacted, without instances nor credentials of the model
+	Config    *config.Config `yaml:"config"`
+	Instances []RunInstance  `yaml:"instances"`
+}
+
+type RunInstance struct {
+	Identifier string `yaml:"identifier"`
+	// Error is the failed step, other fields being partial
+	Error string `yaml:"error,omitempty"`
+	// Size is the uncompressed size of files, Stored the compressed size added to the store
+	Size   int64 `yaml:"size"`
+	Stored int64 `yaml:"stored"`
+	// Manifest is the SHA-256 of the instance manifest, which holds hashes of all files
+	Manifest string `yaml:"manifest,omitempty"`
+	// Dump is the SHA-256 of the SQL dump, Tables the number of tables it was taken from
+	Dump   string `yaml:"dump,omitempty"`
+	Tables int    `yaml:"tables"`
+	// WordpressVersion is read from wp-includes/version.php of sources
+	WordpressVersion string `yaml:"wordpress-version,omitempty"`
+}
+
+// Instance returns the record of an instance, nil when not backed up by the run
+func (m *RunManifest) Instance(identifier string) *RunInstance {
+	for i := range m.Instances {
+		if m.Instances[i].Identifier == identifier {
+			return &m.Instances[i]
+		}
+	}
+	return nil
+}
+
+func ReadRunManifest(path string) (*RunManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read run manifest: %w", err)
+	}
+	var manifest RunManifest
+	if err := yaml.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse run manifest %s: %w", path, err)
+	}
+	return &manifest, nil
+}
+
+func WriteRunManifest(path string, m *RunManifest) error {
+	data, err := yaml.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to marshal run manifest: %w", err)
+	}
+	return os.WriteFile(path, data, 0644)
+}
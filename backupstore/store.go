@@ -0,0 +1,434 @@
+package backupstore
+
+import (
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/quix-labs/multipress/utils"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// ObjectsDir holds stored files, by the first two characters of their hash
+	ObjectsDir = "objects"
+	tmpDir     = "tmp"
+	lockFile   = "lock"
+)
+
+// Store keeps gzipped files named by the SHA-256 of their content, so files identical across instances and runs are stored once.
+// Files are encrypted with age when keys have recipients, and named by an HMAC of their hash.
+type Store struct {
+	root string
+	keys Keys
+}
+
+func New(root string, keys Keys) *Store {
+	return &Store{root: root, keys: keys}
+}
+
+// keyedName names the content of hash when encrypting, so that names do not reveal well-known files, empty otherwise
+func (s *Store) keyedName(hash string) string {
+	if !s.Encrypts() || len(s.keys.nameKey) == 0 {
+		return ""
+	}
+	mac := hmac.New(sha256.New, s.keys.nameKey)
+	mac.Write([]byte(hash))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// objectName is the name of the content of hash for current keys
+func (s *Store) objectName(hash string) string {
+	if name := s.keyedName(hash); name != "" {
+		return name
+	}
+	return hash
+}
+
+// objectPath suffixes names by the fingerprint of recipients when encrypted
+func (s *Store) objectPath(name string) string {
+	file := name
+	if fingerprint := s.keys.fingerprint(); fingerprint != "" {
+		file += "." + fingerprint
+	}
+	return filepath.Join(s.root, ObjectsDir, name[:2], file)
+}
+
+// Has reports whether the content of hash is stored for current keys, so that changing keys stores files again
+func (s *Store) Has(hash string) bool {
+	return utils.FileExists(s.objectPath(s.objectName(hash)))
+}
+
+// Put stores the content of r, returning its hash, its size and the compressed size added to the store, zero when already stored
+func (s *Store) Put(r io.Reader) (string, int64, int64, error) {
+	if err := utils.CreateDirectoryIfNotExists(filepath.Join(s.root, tmpDir)); err != nil {
+		return "", 0, 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Join(s.root, tmpDir), "object.*")
+	if err != nil {
+		return "", 0, 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hasher := sha256.New()
+	ew, err := s.encrypt(tmp)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	gw := gzip.NewWriter(ew)
+	size, err := io.Copy(io.MultiWriter(hasher, gw), r)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	if err := gw.Close(); err != nil {
+		return "", 0, 0, err
+	}
+	if err := ew.Close(); err != nil {
+		return "", 0, 0, err
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		return "", 0, 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", 0, 0, err
+	}
+
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	if s.Has(hash) {
+		return hash, size, 0, nil
+	}
+	objectPath := s.objectPath(s.objectName(hash))
+	if err := utils.CreateDirectoryIfNotExists(filepath.Dir(objectPath)); err != nil {
+		return "", 0, 0, err
+	}
+	if err := os.Rename(tmp.Name(), objectPath); err != nil {
+		return "", 0, 0, err
+	}
+	return hash, size, info.Size(), nil
+}
+
+// Open reads the stored file name, failing at the end of a content not matching hash.
+// Files stored for former keys are read when identities decrypt them.
+func (s *Store) Open(name string, hash string) (io.ReadCloser, error) {
+	candidates, err := filepath.Glob(filepath.Join(s.root, ObjectsDir, name[:2], name+"*"))
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("object %s not found", name)
+	}
+	if index := slices.Index(candidates, s.objectPath(name)); index > 0 {
+		candidates[0], candidates[index] = candidates[index], candidates[0] // Current keys first
+	}
+
+	var errs []error
+	for _, candidate := range candidates {
+		reader, err := s.openObject(candidate, hash)
+		if err == nil {
+			return reader, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, fmt.Errorf("object %s: %w", name, errors.Join(errs...))
+}
+
+// Check reads the stored file name entirely, failing when missing, unreadable with current keys or not matching hash
+func (s *Store) Check(name string, hash string) error {
+	reader, err := s.Open(name, hash)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	_, err = io.Copy(io.Discard, reader)
+	return err
+}
+
+func (s *Store) openObject(path string, hash string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := s.decrypt(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	gr, err := gzip.NewReader(reader)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("corrupted: %w", err)
+	}
+	return &objectReader{file: file, gr: gr, hasher: sha256.New(), hash: hash}, nil
+}
+
+type objectReader struct {
+	file   *os.File
+	gr     *gzip.Reader
+	hasher hash.Hash
+	hash   string
+}
+
+func (r *objectReader) Read(p []byte) (int, error) {
+	n, err := r.gr.Read(p)
+	r.hasher.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(r.hasher.Sum(nil)) != r.hash {
+		return n, fmt.Errorf("object %s is corrupted: content does not match its hash", r.hash)
+	}
+	return n, err
+}
+
+func (r *objectReader) Close() error {
+	r.gr.Close()
+	return r.file.Close()
+}
+
+// Entry is a file, directory or symbolic link of a backup, Path being slash-separated
+type Entry struct {
+	Path    string      `json:"path"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mod-time"`
+	Size    int64       `json:"size,omitempty"`
+	Hash    string      `json:"hash,omitempty"`
+	// Object names the stored file when keyed, the file being named by Hash otherwise
+	Object string `json:"object,omitempty"`
+	Link   string `json:"link,omitempty"`
+}
+
+// ObjectName is the name of the stored file of the entry
+func (e Entry) ObjectName() string {
+	if e.Object != "" {
+		return e.Object
+	}
+	return e.Hash
+}
+
+// AddDirectory stores files of dir as entries under prefix, returning them with the size added to the store.
+// Unchanged files of previous entries (same size and modification time) are not read again.
+func (s *Store) AddDirectory(dir string, prefix string, previous map[string]Entry) ([]Entry, int64, error) {
+	var entries []Entry
+	var added int64
+	err := filepath.WalkDir(dir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil || rel == "." {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		entry := Entry{Path: path.Join(prefix, filepath.ToSlash(rel)), Mode: info.Mode(), ModTime: info.ModTime()}
+		switch {
+		case info.IsDir():
+		case info.Mode()&fs.ModeSymlink != 0:
+			if entry.Link, err = os.Readlink(filePath); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			entry.Size = info.Size()
+			if prev, exists := previous[entry.Path]; exists && prev.Hash != "" && prev.Size == entry.Size && prev.ModTime.Equal(entry.ModTime) && s.Has(prev.Hash) {
+				entry.Hash, entry.Object = prev.Hash, s.keyedName(prev.Hash)
+				break
+			}
+			hash, stored, err := s.putFile(filePath)
+			if err != nil {
+				return err
+			}
+			entry.Hash, entry.Object = hash, s.keyedName(hash)
+			added += stored
+		default:
+			return nil // Sockets, pipes and devices are not backed up
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, added, err
+}
+
+// putFile hashes the file before storing it, compressing and encrypting only new files
+func (s *Store) putFile(filePath string) (string, int64, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", 0, err
+	}
+	if hash := hex.EncodeToString(hasher.Sum(nil)); s.Has(hash) {
+		return hash, 0, nil
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+
+	hash, _, added, err := s.Put(file)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to store %s: %w", filePath, err)
+	}
+	return hash, added, nil
+}
+
+// Extract writes entries under prefix into target, which is created when missing
+func (s *Store) Extract(entries []Entry, prefix string, target string) error {
+	if err := utils.CreateDirectoryIfNotExists(target); err != nil {
+		return err
+	}
+
+	// Modes and times of directories are applied last, files being written into them
+	var directories []Entry
+	for _, entry := range entries {
+		rel, ok := relativePath(entry.Path, prefix)
+		if !ok {
+			continue
+		}
+		if !filepath.IsLocal(rel) {
+			return fmt.Errorf("invalid backup entry %q", entry.Path)
+		}
+		destination := filepath.Join(target, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+			return err
+		}
+
+		switch {
+		case entry.Mode.IsDir():
+			if err := os.MkdirAll(destination, 0755); err != nil {
+				return err
+			}
+			entry.Path = destination
+			directories = append(directories, entry)
+		case entry.Mode&fs.ModeSymlink != 0:
+			if err := os.Symlink(entry.Link, destination); err != nil {
+				return err
+			}
+		default:
+			if err := s.extractFile(entry, destination); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := len(directories) - 1; i >= 0; i-- {
+		if err := os.Chmod(directories[i].Path, directories[i].Mode.Perm()); err != nil {
+			return err
+		}
+		if err := os.Chtimes(directories[i].Path, directories[i].ModTime, directories[i].ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadFile returns the content of the entry at path
+func (s *Store) ReadFile(entries []Entry, entryPath string) ([]byte, error) {
+	for _, entry := range entries {
+		if entry.Path == entryPath && entry.Mode.IsRegular() {
+			reader, err := s.Open(entry.ObjectName(), entry.Hash)
+			if err != nil {
+				return nil, err
+			}
+			defer reader.Close()
+			return io.ReadAll(reader)
+		}
+	}
+	return nil, fmt.Errorf("%s not found in backup", entryPath)
+}
+
+func (s *Store) extractFile(entry Entry, destination string) error {
+	reader, err := s.Open(entry.ObjectName(), entry.Hash)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	file, err := os.OpenFile(destination, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, entry.Mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, reader); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to restore %s: %w", entry.Path, err)
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(destination, entry.ModTime, entry.ModTime)
+}
+
+func relativePath(entryPath string, prefix string) (string, bool) {
+	if prefix == "" {
+		return entryPath, true
+	}
+	return strings.CutPrefix(entryPath, prefix+"/")
+}
+
+// Lock waits for the store lock, held until the returned function is called.
+// Backups share it, while Collect needs it exclusive: objects of a running backup are not referenced by a manifest yet.
+func (s *Store) Lock(exclusive bool) (func() error, error) {
+	if err := os.MkdirAll(s.root, 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filepath.Join(s.root, lockFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	if err := syscall.Flock(int(file.Fd()), how); err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to lock the backup store: %w", err), file.Close())
+	}
+	return file.Close, nil // Closing the file releases the lock
+}
+
+// Collect removes objects not referenced, returning the count and size of removed objects.
+// It must run under the exclusive lock, taken before listing referenced objects.
+func (s *Store) Collect(referenced map[string]bool) (int, int64, error) {
+	if err := utils.RemoveDirectory(filepath.Join(s.root, tmpDir), true); err != nil {
+		return 0, 0, err
+	}
+
+	removed, freed := 0, int64(0)
+	err := filepath.WalkDir(filepath.Join(s.root, ObjectsDir), func(filePath string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return fs.SkipAll
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if name, _, _ := strings.Cut(d.Name(), "."); referenced[name] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if err := utils.RemoveFile(filePath); err != nil {
+			return err
+		}
+		removed++
+		freed += info.Size()
+		return nil
+	})
+	return removed, freed, err
+}
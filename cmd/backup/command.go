@@ -2,27 +2,88 @@ package backup
 
 import (
 	_ "embed"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/docker/docker/api/types/container"
+	"github.com/quix-labs/multipress/backupstore"
 	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/database"
+	"github.com/quix-labs/multipress/stack"
 	"github.com/quix-labs/multipress/utils"
 	"github.com/urfave/cli/v2"
 	"golang.org/x/sync/errgroup"
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
 	"time"
 )
 
 func Command() *cli.Command {
 	return &cli.Command{
-		Name:   "backup",
-		Usage:  "Generate backups",
+		Name:      "backup",
+		Usage:     "Generate backups",
+		ArgsUsage: "[identifiers...]",
+		Flags: []cli.Flag{
+			stack.SelectFlag(),
+		},
 		Action: action,
+		Subcommands: []*cli.Command{
+			{
+				Name:  "prune",
+				Usage: "Remove old backups, keeping the most recent ones",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:     "keep",
+						Usage:    "Number of most recent backups to keep",
+						Required: true,
+					},
+				},
+				Action: pruneAction,
+			},
+			{
+				Name:      "decrypt",
+				Usage:     "Decrypt a manifest or a file of the store, e.g. from an offsite copy",
+				ArgsUsage: "<file>",
+				Flags: []cli.Flag{
+					IdentityFlag(),
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Usage:   "Output file, standard output when empty",
+					},
+				},
+				Action: decryptAction,
+			},
+			{
+				Name:  "upload",
+				Usage: "Copy backups to backup-targets of multipress.yaml and apply their retention, as after each backup",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:  "target",
+						Usage: "Name of a target, all when not set (repeatable)",
+					},
+				},
+				Action: uploadAction,
+			},
+			{
+				Name:      "verify",
+				Usage:     "Check checksums of a backup and test-restore its dumps into a throwaway database",
+				ArgsUsage: "[date]",
+				Flags: []cli.Flag{
+					IdentityFlag(),
+				},
+				Action: verifyAction,
+			},
+		},
 	}
 }
 
+const configPath = "multipress.yaml"
+
 const folderDataFormat = "20060102_150405"
 
 type InstanceStep struct {
@@ -38,48 +99,115 @@ type Step struct {
 var preSteps = []Step{
 	{"Create backups directory", createBackupsDirectory},
 	{"Create backups/date directory", createBackupsDateDirectory},
+	{"Load encryption keys", openStore},
+	{"Configure backup server access", configureBackupServer},
 	{"Deploy backup server", deployBackupServer},
 }
 
 var steps = []InstanceStep{
 	{"Create backup/date/instance directories", createInstanceBackupDir},
 	{"Generate SQL Dumps", dumpSqlInstance},
-	{"Copy Sources", copyInstanceSource},
+	{"Inspect databases and WordPress", inspectInstance},
 	{"Copy compose.yaml files", copyInstanceCompose},
-	{"Compress Backups", createArchiveInstance},
+	{"Store files and manifests", storeInstance},
 	{"Delete backup/date/instance directories", deleteInstanceBackupDir},
 }
 
 func action(c *cli.Context) error {
-	configPath := "multipress.yaml"
 	cfg, err := config.LoadConfig(configPath)
 	if err != nil {
 		fmt.Println(err)
 		return err
 	}
 
+	identifiers, err := stack.SelectedInstances(c, cfg, c.Args().Slice())
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	startDate, results, err := Run(c, cfg, identifiers)
+	if err != nil {
+		return err
+	}
+	uploadErr := UploadTargets(c, cfg)
+
+	var size, stored int64
+	for _, result := range results {
+		size += result.Size
+		stored += result.Stored
+	}
+
+	utils.PrintSeparator("Backup finished", '═')
+	fmt.Printf("Size: %s, %s added to the store\n", utils.FormatBytes(size), utils.FormatBytes(stored))
+	if cfg.Backups.Enabled {
+		fmt.Printf("URL: %s/%s\n", cfg.BackupsUrl(), startDate.Format(folderDataFormat))
+		fmt.Printf("User: %s\n", cfg.Backups.Username)
+		fmt.Printf("Password: %s\n", cfg.Backups.Password)
+	} else {
+		fmt.Printf("Path: %s\n", filepath.Join(cfg.BackupsPath(), startDate.Format(folderDataFormat)))
+	}
+	utils.PrintSeparator("", '═')
+
+	if uploadErr != nil {
+		fmt.Println(uploadErr)
+		return uploadErr
+	}
+	return nil
+}
+
+// InstanceResult is the outcome of an instance backup
+type InstanceResult struct {
+	// Err is the first failed step, skipped steps excluded
+	Err error
+	// Size is the size of backed up files in bytes
+	Size int64
+	// Stored is the compressed size added to the store in bytes, unchanged files being shared
+	Stored int64
+}
+
+// Run backups instances into a new dated directory and records the run, returning its date and results by instance.
+// Results are also returned when only the run manifest failed. Targets are not uploaded, see UploadTargets.
+func Run(c *cli.Context, cfg *config.Config, identifiers []string) (time.Time, map[string]InstanceResult, error) {
 	startDate := time.Now()
+	inspections = make(map[string]backupstore.RunInstance, len(identifiers))
 
 	utils.PrintSeparator("Pre-Steps", '═')
 	for _, step := range preSteps {
 		if err := utils.Spin(utils.SpinOptions{Label: step.Label}, func() error {
 			return step.Run(c, cfg, startDate)
 		}); err != nil {
-			return err
+			return startDate, nil, err
 		}
 	}
 
+	unlock, err := backupstore.New(cfg.BackupsStorePath(), backupstore.Keys{}).Lock(false) // Locking needs no key
+	if err != nil {
+		return startDate, nil, err
+	}
+	defer unlock()
+
+	results := make(map[string]InstanceResult, len(identifiers))
+	var resultsLock sync.Mutex
+
 	// Replicate instances, // Run all steps in parallel
 	utils.PrintSeparator("Backup instances", '═')
 	for _, step := range steps {
 		if err := utils.Spin(utils.SpinOptions{Label: step.Label}, func() error {
 			var g errgroup.Group
 
-			for identifier, _ := range cfg.Instances.Credentials {
+			for _, identifier := range identifiers {
 				identifier := identifier // Important local copy
 				g.Go(func() error {
 					if err := step.Run(c, cfg, identifier, startDate); err != nil {
 						fmt.Println(err) // TODO HANDLE MULTIPLE PROGRESS PARALLEL FAILABLE
+						if !errors.As(err, &utils.SkippedError{}) {
+							resultsLock.Lock()
+							if results[identifier].Err == nil {
+								results[identifier] = InstanceResult{Err: fmt.Errorf("%s: %w", step.Label, err)}
+							}
+							resultsLock.Unlock()
+						}
 					}
 					return nil
 				})
@@ -87,17 +215,237 @@ func action(c *cli.Context) error {
 
 			return g.Wait()
 		}); err != nil {
-			return err
+			return startDate, results, err
 		}
 	}
 
-	utils.PrintSeparator("Backup finished", '═')
-	fmt.Printf("URL: %s/%s\n", cfg.BackupsUrl(), startDate.Format(folderDataFormat))
-	utils.PrintSeparator("", '═')
+	for _, identifier := range identifiers {
+		result := results[identifier]
+		if manifest, err := LoadManifest(cfg, startDate.Format(folderDataFormat), identifier); err == nil {
+			result.Size, result.Stored = manifest.Size, manifest.Stored
+		} else if result.Err == nil {
+			result.Err = err
+		}
+		results[identifier] = result
+	}
 
+	if err := utils.Spin(utils.SpinOptions{Label: "Write run manifest"}, func() error {
+		return writeRunManifest(cfg, startDate, identifiers, results)
+	}); err != nil {
+		return startDate, results, err
+	}
+	return startDate, results, nil
+}
+
+func pruneAction(c *cli.Context) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	removed, freed, err := Prune(cfg, c.Int("keep"))
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	fmt.Printf("%d backup(s) removed, %s freed\n", len(removed), utils.FormatBytes(freed))
+	for _, date := range removed {
+		fmt.Printf("  %s\n", date)
+	}
 	return nil
 }
 
+// IdentityFlag is the file of private keys decrypting backups encrypted to recipients
+func IdentityFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:    "identity",
+		Aliases: []string{"i"},
+		Usage:   fmt.Sprintf("File of age private keys of backup recipients (default: $%s)", backupstore.IdentityEnv),
+	}
+}
+
+func decryptAction(c *cli.Context) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	if c.NArg() != 1 {
+		err := errors.New("expected the file to decrypt")
+		fmt.Println(err)
+		return err
+	}
+
+	store, err := Store(cfg, c.String("identity"))
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	var output io.Writer = os.Stdout
+	if path := c.String("output"); path != "" {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			fmt.Println(err)
+			return err
+		}
+		defer file.Close()
+		output = file
+	}
+
+	if err := decryptFile(store, c.Args().First(), output); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	return nil
+}
+
+func decryptFile(store *backupstore.Store, path string, output io.Writer) error {
+	if filepath.Ext(path) == manifestExtension {
+		manifest, err := backupstore.ReadManifest(path)
+		if err != nil {
+			return err
+		}
+		if err := store.Unseal(manifest); err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = output.Write(append(data, '\n'))
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return store.Decrypt(file, output)
+}
+
+// Prune removes dated backups beyond the keep most recent ones, then stored files they only used.
+// It returns removed directories and the freed size of the store.
+func Prune(cfg *config.Config, keep int) ([]string, int64, error) {
+	if keep < 1 {
+		return nil, 0, errors.New("at least one backup must be kept")
+	}
+
+	// Objects are listed in plain text, no key being needed
+	store := backupstore.New(cfg.BackupsStorePath(), backupstore.Keys{})
+
+	// Held from listing backups, a backup running meanwhile would lose objects not referenced yet
+	unlock, err := store.Lock(true)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer unlock()
+
+	dates, err := Dates(cfg)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(dates) <= keep {
+		return nil, 0, nil
+	}
+
+	expired := dates[:len(dates)-keep]
+	for i, date := range expired {
+		if err := utils.RemoveDirectory(filepath.Join(cfg.BackupsPath(), date), true); err != nil {
+			return expired[:i], 0, err
+		}
+	}
+
+	referenced := make(map[string]bool)
+	for _, date := range dates[len(dates)-keep:] {
+		manifests, err := filepath.Glob(filepath.Join(cfg.BackupsPath(), date, "*"+manifestExtension))
+		if err != nil {
+			return expired, 0, err
+		}
+		for _, manifestPath := range manifests {
+			manifest, err := backupstore.ReadManifest(manifestPath)
+			if err != nil {
+				return expired, 0, err // Unknown references, nothing is collected
+			}
+			for _, hash := range manifest.Objects {
+				referenced[hash] = true
+			}
+		}
+	}
+	_, freed, err := store.Collect(referenced)
+	return expired, freed, err
+}
+
+// manifestExtension is appended to instance identifiers in dated directories
+const manifestExtension = ".json"
+
+// Dates returns dated backup directories, oldest first
+func Dates(cfg *config.Config) ([]string, error) {
+	entries, err := os.ReadDir(cfg.BackupsPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Names sort chronologically, other directories (e.g. the store) are ignored
+	var dates []string
+	for _, entry := range entries {
+		if _, err := time.Parse(folderDataFormat, entry.Name()); err == nil && entry.IsDir() {
+			dates = append(dates, entry.Name())
+		}
+	}
+	slices.Sort(dates)
+	return dates, nil
+}
+
+// Store opens the backup store with keys of the configuration, identityPath holding private keys of recipients
+func Store(cfg *config.Config, identityPath string) (*backupstore.Store, error) {
+	if cfg.BackupEncryption != nil && cfg.BackupEncryption.EnsureNameKey() {
+		if err := cfg.SaveAs(configPath); err != nil {
+			return nil, err
+		}
+	}
+	keys, err := backupstore.LoadKeys(cfg.BackupsStorePath(), cfg.BackupEncryption, identityPath)
+	if err != nil {
+		return nil, err
+	}
+	return backupstore.New(cfg.BackupsStorePath(), keys), nil
+}
+
+// runManifestName is the run manifest of dated directories, next to instance manifests
+const runManifestName = "run.yaml"
+
+func RunManifestPath(cfg *config.Config, date string) string {
+	return filepath.Join(cfg.BackupsPath(), date, runManifestName)
+}
+
+func ManifestPath(cfg *config.Config, date string, identifier string) string {
+	return filepath.Join(cfg.BackupsPath(), date, identifier+manifestExtension)
+}
+
+func LoadManifest(cfg *config.Config, date string, identifier string) (*backupstore.Manifest, error) {
+	return backupstore.ReadManifest(ManifestPath(cfg, date, identifier))
+}
+
+// LatestManifest returns the date and manifest of the most recent backup of an instance
+func LatestManifest(cfg *config.Config, identifier string) (string, *backupstore.Manifest, error) {
+	dates, err := Dates(cfg)
+	if err != nil {
+		return "", nil, err
+	}
+	for i := len(dates) - 1; i >= 0; i-- {
+		if utils.FileExists(ManifestPath(cfg, dates[i], identifier)) {
+			manifest, err := LoadManifest(cfg, dates[i], identifier)
+			return dates[i], manifest, err
+		}
+	}
+	return "", nil, fmt.Errorf("no backup of %s found", identifier)
+}
+
 func createBackupsDirectory(c *cli.Context, cfg *config.Config, start time.Time) error {
 	volumePath := cfg.BackupsPath()
 	if exists, err := utils.DirectoryExists(volumePath); err != nil || exists {
@@ -137,6 +485,20 @@ func createBackupsDateDirectory(c *cli.Context, cfg *config.Config, start time.T
 	return nil
 }
 
+// store is opened once per run, unlocking keys being costly
+var store *backupstore.Store
+
+func openStore(c *cli.Context, cfg *config.Config, start time.Time) error {
+	var err error
+	if store, err = Store(cfg, ""); err != nil {
+		return err
+	}
+	if !store.Encrypts() {
+		return utils.SkippedError{Msg: "backups are not encrypted"}
+	}
+	return nil
+}
+
 func createInstanceBackupDir(c *cli.Context, cfg *config.Config, identifier string, start time.Time) error {
 	backupDir := filepath.Join(cfg.BackupsPath(), start.Format(folderDataFormat), identifier)
 	if exists, err := utils.DirectoryExists(backupDir); err != nil || exists {
@@ -163,13 +525,18 @@ func dumpSqlInstance(c *cli.Context, cfg *config.Config, identifier string, star
 		return errors.New("instance credentials does not exist")
 	}
 
+	dialect, err := database.DialectOf(cfg)
+	if err != nil {
+		return err
+	}
+
 	dumpPath := filepath.Join(cfg.BackupsPath(), start.Format(folderDataFormat), identifier, "dump.sql")
 	output, err := utils.ExecDockerCmd(cfg.MysqlContainerName(), container.ExecOptions{
 		Env: []string{fmt.Sprintf(`MYSQL_PWD=%s`, cfg.MySql.RootPassword)},
-		Cmd: []string{"mysqldump", "-u", "root", credentials.DBName},
+		Cmd: dialect.DumpCommand("root", credentials.DBName),
 	}, nil)
 	if err != nil {
-		return fmt.Errorf("failed to execute mysqldump: %w", err)
+		return fmt.Errorf("failed to dump database: %w", err)
 	}
 
 	if err := os.WriteFile(dumpPath, []byte(output), 0644); err != nil {
@@ -178,37 +545,127 @@ func dumpSqlInstance(c *cli.Context, cfg *config.Config, identifier string, star
 	return nil
 }
 
-func copyInstanceSource(c *cli.Context, cfg *config.Config, identifier string, start time.Time) error {
-	sourcesDumpPath := filepath.Join(cfg.BackupsPath(), start.Format(folderDataFormat), identifier, "sources")
-	return utils.CopyDirectory(cfg.InstanceVolumePath(identifier), sourcesDumpPath)
+// inspections are recorded by inspectInstance for the run manifest
+var (
+	inspections     map[string]backupstore.RunInstance
+	inspectionsLock sync.Mutex
+)
+
+// inspectInstance records the checksum of the dump, the tables it was taken from and the WordPress version
+func inspectInstance(c *cli.Context, cfg *config.Config, identifier string, start time.Time) error {
+	dumpPath := filepath.Join(cfg.BackupsPath(), start.Format(folderDataFormat), identifier, "dump.sql")
+	dumpSum, err := utils.FileSha256(dumpPath)
+	if err != nil {
+		return fmt.Errorf("failed to hash dump: %w", err)
+	}
+
+	db, err := database.Connect(cfg, "")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tables, err := database.CountTables(db, cfg.Instances.Credentials[identifier].DBName)
+	if err != nil {
+		return err
+	}
+
+	version, err := wordpressVersion(cfg.InstanceVolumePath(identifier))
+	if err != nil {
+		return err
+	}
+
+	inspectionsLock.Lock()
+	defer inspectionsLock.Unlock()
+	inspections[identifier] = backupstore.RunInstance{Dump: dumpSum, Tables: tables, WordpressVersion: version}
+	return nil
+}
+
+var wordpressVersionPattern = regexp.MustCompile(`\$wp_version\s*=\s*['"]([^'"]+)['"]`)
+
+// wordpressVersion reads the version from sources, as WordPress itself does
+func wordpressVersion(volumePath string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(volumePath, "wp-includes", "version.php"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read WordPress version: %w", err)
+	}
+	match := wordpressVersionPattern.FindSubmatch(data)
+	if match == nil {
+		return "", errors.New("WordPress version not found in wp-includes/version.php")
+	}
+	return string(match[1]), nil
 }
 
 func copyInstanceCompose(c *cli.Context, cfg *config.Config, identifier string, start time.Time) error {
-	srcComposePath := fmt.Sprintf("compose.%s.yaml", identifier)
+	srcComposePath := cfg.InstanceComposePath(identifier)
 	dstComposePath := filepath.Join(cfg.BackupsPath(), start.Format(folderDataFormat), identifier, "compose.yaml")
 	return utils.CopyFile(srcComposePath, dstComposePath)
 }
 
-func createArchiveInstance(c *cli.Context, cfg *config.Config, identifier string, start time.Time) error {
+// storeInstance stores the dump, compose file and sources, reading only files changed since the previous backup
+func storeInstance(c *cli.Context, cfg *config.Config, identifier string, start time.Time) error {
 	instancePath := filepath.Join(cfg.BackupsPath(), start.Format(folderDataFormat), identifier)
-	zipPath := filepath.Join(cfg.BackupsPath(), start.Format(folderDataFormat), identifier+".tar.gz")
 
-	reader, err := utils.TarGzDirectory(instancePath)
+	// Without private keys of recipients, unchanged files are read again but still stored once
+	var previous map[string]backupstore.Entry
+	if _, manifest, err := LatestManifest(cfg, identifier); err == nil && store.Unseal(manifest) == nil {
+		previous = manifest.Index()
+	}
+
+	manifest := &backupstore.Manifest{
+		Identifier: identifier,
+		Date:       start.Truncate(time.Second),
+		Contents: backupstore.Contents{
+			Credentials: cfg.Instances.Credentials[identifier],
+			Metadata:    cfg.Instances.Metadata[identifier],
+		},
+	}
+
+	entries, stored, err := store.AddDirectory(instancePath, "", nil)
 	if err != nil {
 		return err
 	}
+	manifest.Entries, manifest.Stored = entries, stored
 
-	archiveFile, err := os.Create(zipPath)
+	entries, stored, err = store.AddDirectory(cfg.InstanceVolumePath(identifier), "sources", previous)
 	if err != nil {
-		return fmt.Errorf("failed to create archive file %s: %w", zipPath, err)
+		return err
 	}
-	defer archiveFile.Close()
+	manifest.Entries, manifest.Stored = append(manifest.Entries, entries...), manifest.Stored+stored
 
-	if _, err := io.Copy(archiveFile, reader); err != nil {
-		return fmt.Errorf("failed to compress: %w", err)
+	return store.WriteManifest(ManifestPath(cfg, start.Format(folderDataFormat), identifier), manifest)
+}
+
+// writeRunManifest records the run, readable without keys, failed instances included
+func writeRunManifest(cfg *config.Config, start time.Time, identifiers []string, results map[string]InstanceResult) error {
+	snapshot, err := cfg.Redacted()
+	if err != nil {
+		return err
+	}
+	// Usernames, database names and metadata of instances stay in their manifests, encrypted with them
+	snapshot.Instances = nil
+	if snapshot.Model != nil {
+		snapshot.Model.Credentials = config.CredentialsConfig{}
 	}
 
-	return nil
+	manifest := &backupstore.RunManifest{
+		Version: utils.Version(),
+		Date:    start.Truncate(time.Second),
+		Config:  snapshot,
+	}
+	for _, identifier := range identifiers {
+		instance := inspections[identifier]
+		instance.Identifier = identifier
+		result := results[identifier]
+		instance.Size, instance.Stored = result.Size, result.Stored
+		if result.Err != nil {
+			instance.Error = result.Err.Error()
+		}
+		if sum, err := utils.FileSha256(ManifestPath(cfg, start.Format(folderDataFormat), identifier)); err == nil {
+			instance.Manifest = sum
+		}
+		manifest.Instances = append(manifest.Instances, instance)
+	}
+	return backupstore.WriteRunManifest(RunManifestPath(cfg, start.Format(folderDataFormat)), manifest)
 }
 
 func deleteInstanceBackupDir(c *cli.Context, cfg *config.Config, identifier string, start time.Time) error {
@@ -226,12 +683,37 @@ func deleteInstanceBackupDir(c *cli.Context, cfg *config.Config, identifier stri
 //go:embed tmpl/backup.yaml.tmpl
 var backupTmpl string
 
+func configureBackupServer(c *cli.Context, cfg *config.Config, start time.Time) error {
+	if cfg.Backups == nil {
+		cfg.Backups = config.NewDefaultAccessConfig()
+	}
+	changed, err := cfg.Backups.EnsurePasswordHash()
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return utils.SkippedError{Msg: "Configuration already defined"}
+	}
+	return cfg.SaveAs(configPath)
+}
+
 func deployBackupServer(c *cli.Context, cfg *config.Config, start time.Time) error {
-	if err := utils.ParseTemplateToFile(backupTmpl, cfg, "compose.backup.yaml"); err != nil {
+	if !cfg.Backups.Enabled {
+		// Remove the server previously deployed
+		if !utils.FileExists(cfg.BackupsComposePath()) {
+			return utils.SkippedError{Msg: "backup server disabled"}
+		}
+		if _, err := utils.DownComposeFile(cfg.BackupsComposePath()); err != nil {
+			return err
+		}
+		return utils.RemoveFile(cfg.BackupsComposePath())
+	}
+
+	if err := utils.ParseTemplateToFile(backupTmpl, cfg, cfg.BackupsComposePath()); err != nil {
 		return err
 	}
 
-	if _, err := utils.UpComposeFile("compose.backup.yaml"); err != nil {
+	if _, err := utils.UpComposeFile(cfg.BackupsComposePath()); err != nil {
 		return err
 	}
 
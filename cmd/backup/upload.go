@@ -0,0 +1,315 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/quix-labs/multipress/backupstore"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/storage"
+	"github.com/quix-labs/multipress/utils"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/sync/errgroup"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// uploadConcurrency is the number of files uploaded at once, stored files being small and many
+const uploadConcurrency = 8
+
+func uploadAction(c *cli.Context) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	targets, err := selectedTargets(cfg, c.StringSlice("target"))
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	var errs []error
+	for _, target := range targets {
+		var report SyncReport
+		if err := utils.Spin(utils.SpinOptions{Label: "Upload to " + target.Name}, func() error {
+			report, err = Sync(c.Context, cfg, target)
+			return err
+		}); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", target.Name, err))
+			continue
+		}
+		fmt.Printf("%s: %d file(s) uploaded (%s), %d file(s) removed by retention\n", target.Name, report.Uploaded, utils.FormatBytes(report.Bytes), report.Removed)
+	}
+	return errors.Join(errs...)
+}
+
+func selectedTargets(cfg *config.Config, names []string) ([]config.BackupTargetConfig, error) {
+	if len(cfg.BackupTargets) == 0 {
+		return nil, errors.New("no backup-targets configured in multipress.yaml")
+	}
+	if len(names) == 0 {
+		return cfg.BackupTargets, nil
+	}
+
+	var targets []config.BackupTargetConfig
+	for _, name := range names {
+		index := slices.IndexFunc(cfg.BackupTargets, func(t config.BackupTargetConfig) bool { return t.Name == name })
+		if index < 0 {
+			return nil, fmt.Errorf("unknown backup target %q", name)
+		}
+		targets = append(targets, cfg.BackupTargets[index])
+	}
+	return targets, nil
+}
+
+// UploadTargets synchronizes all targets after a backup, a failing target not preventing others.
+// Backups remain complete locally when it fails.
+func UploadTargets(c *cli.Context, cfg *config.Config) error {
+	if len(cfg.BackupTargets) == 0 {
+		return nil
+	}
+
+	// Shared with backups, so that prune does not remove stored files being uploaded
+	unlock, err := backupstore.New(cfg.BackupsStorePath(), backupstore.Keys{}).Lock(false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	utils.PrintSeparator("Upload", '═')
+	var errs []error
+	for _, target := range cfg.BackupTargets {
+		if err := utils.Spin(utils.SpinOptions{Label: "Upload to " + target.Name}, func() error {
+			_, err := Sync(c.Context, cfg, target)
+			return err
+		}); err != nil {
+			errs = append(errs, fmt.Errorf("upload to %s: %w", target.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type SyncReport struct {
+	Uploaded int
+	Bytes    int64
+	// Removed is the number of files removed by retention
+	Removed int
+}
+
+// Sync copies backups missing on the target, stored files before manifests so that a manifest on the target is always complete.
+// It then removes backups beyond the retention of the target, and stored files no longer used there.
+func Sync(ctx context.Context, cfg *config.Config, targetCfg config.BackupTargetConfig) (SyncReport, error) {
+	var report SyncReport
+
+	target, err := storage.Open(targetCfg)
+	if err != nil {
+		return report, err
+	}
+	defer target.Close()
+
+	remoteFiles, err := target.List(ctx)
+	if err != nil {
+		return report, fmt.Errorf("failed to list backup target: %w", err)
+	}
+	remote := make(map[string]bool, len(remoteFiles))
+	remoteManifests := make(map[string][]string)
+	for _, file := range remoteFiles {
+		remote[file] = true
+		if date, ok := manifestDate(file); ok {
+			remoteManifests[date] = append(remoteManifests[date], file)
+		}
+	}
+
+	localDates, err := Dates(cfg)
+	if err != nil {
+		return report, err
+	}
+	dates := slices.Clone(localDates)
+	for date := range remoteManifests {
+		if !slices.Contains(dates, date) {
+			dates = append(dates, date)
+		}
+	}
+	slices.Sort(dates)
+	if targetCfg.Keep > 0 && len(dates) > targetCfg.Keep {
+		dates = dates[len(dates)-targetCfg.Keep:]
+	}
+
+	// Stored files referenced by retained backups, local or only remaining on the target
+	referenced := make(map[string]bool)
+	var manifests []string
+	for _, date := range dates {
+		localManifests, err := filepath.Glob(filepath.Join(cfg.BackupsPath(), date, "*"+manifestExtension))
+		if err != nil {
+			return report, err
+		}
+		for _, localManifest := range localManifests {
+			manifest, err := backupstore.ReadManifest(localManifest)
+			if err != nil {
+				return report, err
+			}
+			for _, hash := range manifest.Objects {
+				referenced[hash] = true
+			}
+			if file := date + "/" + filepath.Base(localManifest); !remote[file] {
+				manifests = append(manifests, file)
+			}
+		}
+		// The run manifest last, as it covers instance manifests
+		if file := date + "/" + runManifestName; utils.FileExists(localPath(cfg, file)) && !remote[file] {
+			manifests = append(manifests, file)
+		}
+		for _, file := range remoteManifests[date] {
+			if path.Ext(file) != manifestExtension || utils.FileExists(localPath(cfg, file)) {
+				continue
+			}
+			manifest, err := readRemoteManifest(ctx, target, file)
+			if err != nil {
+				return report, err
+			}
+			for _, hash := range manifest.Objects {
+				referenced[hash] = true
+			}
+		}
+	}
+
+	var objects []string
+	if file := path.Join(storeDir, backupstore.KeyFile); utils.FileExists(localPath(cfg, file)) && !remote[file] {
+		objects = append(objects, file)
+	}
+	if err := filepath.WalkDir(filepath.Join(cfg.BackupsStorePath(), backupstore.ObjectsDir), func(filePath string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return fs.SkipAll
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		hash, _, _ := strings.Cut(d.Name(), ".")
+		file := path.Join(storeDir, backupstore.ObjectsDir, d.Name()[:2], d.Name())
+		if referenced[hash] && !remote[file] {
+			objects = append(objects, file)
+		}
+		return nil
+	}); err != nil {
+		return report, err
+	}
+
+	var uploaded, uploadedBytes atomic.Int64
+	g, groupCtx := errgroup.WithContext(ctx)
+	g.SetLimit(uploadConcurrency)
+	for _, file := range objects {
+		g.Go(func() error {
+			size, err := uploadFile(groupCtx, target, cfg, file)
+			if err != nil {
+				return err
+			}
+			uploaded.Add(1)
+			uploadedBytes.Add(size)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return report, err
+	}
+	for _, file := range manifests {
+		size, err := uploadFile(ctx, target, cfg, file)
+		if err != nil {
+			return report, err
+		}
+		uploaded.Add(1)
+		uploadedBytes.Add(size)
+	}
+	report.Uploaded, report.Bytes = int(uploaded.Load()), uploadedBytes.Load()
+
+	// Retention, manifests before the files they use
+	var removed []string
+	for date, files := range remoteManifests {
+		if !slices.Contains(dates, date) {
+			removed = append(removed, files...)
+		}
+	}
+	for _, file := range remoteFiles {
+		if name, ok := strings.CutPrefix(file, storeDir+"/"+backupstore.ObjectsDir+"/"); ok {
+			if hash, _, _ := strings.Cut(path.Base(name), "."); !referenced[hash] {
+				removed = append(removed, file)
+			}
+		}
+	}
+	for _, file := range removed {
+		if err := target.Delete(ctx, file); err != nil {
+			return report, fmt.Errorf("failed to remove %s: %w", file, err)
+		}
+		report.Removed++
+	}
+	return report, nil
+}
+
+// storeDir is the store relative to the backups directory, as on targets
+const storeDir = "store"
+
+func localPath(cfg *config.Config, file string) string {
+	return filepath.Join(cfg.BackupsPath(), filepath.FromSlash(file))
+}
+
+// manifestDate returns the date of a manifest path of a target, e.g. 20260103_030000/user3.json or 20260103_030000/run.yaml
+func manifestDate(file string) (string, bool) {
+	date, name, found := strings.Cut(file, "/")
+	if !found || strings.Contains(name, "/") || (path.Ext(name) != manifestExtension && name != runManifestName) {
+		return "", false
+	}
+	if _, err := time.Parse(folderDataFormat, date); err != nil {
+		return "", false
+	}
+	return date, true
+}
+
+func readRemoteManifest(ctx context.Context, target storage.Target, file string) (*backupstore.Manifest, error) {
+	reader, err := target.Get(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	manifest, err := backupstore.ParseManifest(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+	return manifest, nil
+}
+
+// uploadFile hashes the file then streams it to the target, which verifies the hash
+func uploadFile(ctx context.Context, target storage.Target, cfg *config.Config, file string) (int64, error) {
+	source, err := os.Open(localPath(cfg, file))
+	if err != nil {
+		return 0, err
+	}
+	defer source.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, source)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := source.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	if err := target.Put(ctx, file, source, size, hex.EncodeToString(hasher.Sum(nil))); err != nil {
+		return 0, fmt.Errorf("failed to upload %s: %w", file, err)
+	}
+	return size, nil
+}
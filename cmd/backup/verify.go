@@ -0,0 +1,236 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/quix-labs/multipress/backupstore"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/database"
+	"github.com/quix-labs/multipress/utils"
+	"github.com/urfave/cli/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// verifyDatabase receives dumps to test, dropped after each instance
+const verifyDatabase = "multipress_verify"
+
+func verifyAction(c *cli.Context) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	if c.NArg() > 1 {
+		err := errors.New("expected at most one backup date")
+		fmt.Println(err)
+		return err
+	}
+
+	date := c.Args().First()
+	if date == "" {
+		dates, err := Dates(cfg)
+		if err != nil {
+			fmt.Println(err)
+			return err
+		}
+		if len(dates) == 0 {
+			err := errors.New("no backup found")
+			fmt.Println(err)
+			return err
+		}
+		date = dates[len(dates)-1]
+	}
+
+	store, err := Store(cfg, c.String("identity"))
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	utils.PrintSeparator("Verify backup "+date, '═')
+	verifications, err := Verify(cfg, store, date)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	t := table.NewWriter()
+	t.SetStyle(table.StyleLight)
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Instance", "Files", "Tables", "WordPress", "Result"})
+	var failed int
+	for _, verification := range verifications {
+		result := "ok"
+		if verification.Err != nil {
+			result = verification.Err.Error()
+			failed++
+		}
+		t.AppendRow(table.Row{verification.Identifier, verification.Files, verification.Tables, verification.WordpressVersion, result})
+	}
+	t.Render()
+
+	if failed > 0 {
+		err := fmt.Errorf("%d of %d instance backup(s) failed verification", failed, len(verifications))
+		fmt.Println(err)
+		return err
+	}
+	return nil
+}
+
+// Verification is the outcome of the verification of an instance backup
+type Verification struct {
+	Identifier string
+	// Files is the number of stored files read back
+	Files int
+	// Tables is the number of tables restored from the dump
+	Tables           int
+	WordpressVersion string
+	// Err is the first failed check
+	Err error
+}
+
+// Verify checks instance backups of date against the run manifest, reads back every stored file, then restores each dump
+// into a throwaway database. Backups made before run manifests are checked against their own hashes only.
+func Verify(cfg *config.Config, store *backupstore.Store, date string) ([]Verification, error) {
+	if exists, err := utils.DirectoryExists(filepath.Join(cfg.BackupsPath(), date)); err != nil || !exists {
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("no backup on %s", date)
+	}
+
+	var run *backupstore.RunManifest
+	if utils.FileExists(RunManifestPath(cfg, date)) {
+		var err error
+		if run, err = backupstore.ReadRunManifest(RunManifestPath(cfg, date)); err != nil {
+			return nil, err
+		}
+	}
+
+	// Instances of the run, and any manifest it would not list
+	var identifiers []string
+	if run != nil {
+		for _, instance := range run.Instances {
+			identifiers = append(identifiers, instance.Identifier)
+		}
+	}
+	manifests, err := filepath.Glob(filepath.Join(cfg.BackupsPath(), date, "*"+manifestExtension))
+	if err != nil {
+		return nil, err
+	}
+	for _, manifestPath := range manifests {
+		if identifier := strings.TrimSuffix(filepath.Base(manifestPath), manifestExtension); !slices.Contains(identifiers, identifier) {
+			identifiers = append(identifiers, identifier)
+		}
+	}
+
+	verifications := make([]Verification, 0, len(identifiers))
+	for _, identifier := range identifiers {
+		verification := Verification{Identifier: identifier}
+		var recorded *backupstore.RunInstance
+		if run != nil {
+			recorded = run.Instance(identifier)
+		}
+		_ = utils.Spin(utils.SpinOptions{Label: "Verify " + identifier}, func() error {
+			verification.Err = verifyInstance(cfg, store, date, recorded, &verification)
+			return verification.Err
+		})
+		verifications = append(verifications, verification)
+	}
+	return verifications, nil
+}
+
+func verifyInstance(cfg *config.Config, store *backupstore.Store, date string, recorded *backupstore.RunInstance, verification *Verification) error {
+	if recorded != nil {
+		verification.WordpressVersion = recorded.WordpressVersion
+		if recorded.Error != "" {
+			return fmt.Errorf("backup failed: %s", recorded.Error)
+		}
+	}
+
+	manifestPath := ManifestPath(cfg, date, verification.Identifier)
+	if !utils.FileExists(manifestPath) {
+		return errors.New("manifest is missing")
+	}
+	if recorded != nil {
+		sum, err := utils.FileSha256(manifestPath)
+		if err != nil {
+			return err
+		}
+		if sum != recorded.Manifest {
+			return fmt.Errorf("checksum mismatch of manifest: %s, recorded %s", sum, recorded.Manifest)
+		}
+	}
+	manifest, err := backupstore.ReadManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	if err := store.Unseal(manifest); err != nil {
+		return err
+	}
+	checked := make(map[string]bool)
+	for _, entry := range manifest.Entries {
+		if name := entry.ObjectName(); name != "" && !checked[name] {
+			if err := store.Check(name, entry.Hash); err != nil {
+				return err
+			}
+			checked[name] = true
+			verification.Files++
+		}
+	}
+
+	index := manifest.Index()
+	dumpEntry, exists := index["dump.sql"]
+	if !exists {
+		return errors.New("dump.sql not found in backup")
+	}
+	if recorded != nil && dumpEntry.Hash != recorded.Dump {
+		return fmt.Errorf("checksum mismatch of dump.sql: %s, recorded %s", dumpEntry.Hash, recorded.Dump)
+	}
+
+	dumpData, err := store.ReadFile(manifest.Entries, "dump.sql")
+	if err != nil {
+		return err
+	}
+	if verification.Tables, err = testRestore(cfg, dumpData); err != nil {
+		return err
+	}
+	if recorded != nil && verification.Tables != recorded.Tables {
+		return fmt.Errorf("%d table(s) restored, %d backed up", verification.Tables, recorded.Tables)
+	}
+	return nil
+}
+
+// testRestore imports the dump into verifyDatabase, returning the number of restored tables
+func testRestore(cfg *config.Config, dumpData []byte) (int, error) {
+	dialect, err := database.DialectOf(cfg)
+	if err != nil {
+		return 0, err
+	}
+
+	db, err := database.Connect(cfg, "")
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	if err := database.ExecStatements(db, dialect.ScratchStatements(verifyDatabase)); err != nil {
+		return 0, err
+	}
+	defer database.ExecStatements(db, dialect.DropDatabaseStatements(verifyDatabase))
+
+	output, err := utils.ExecDockerCmd(cfg.MysqlContainerName(), container.ExecOptions{
+		Env: []string{fmt.Sprintf(`MYSQL_PWD=%s`, cfg.MySql.RootPassword)},
+		Cmd: dialect.ClientCommand("root", verifyDatabase),
+	}, dumpData)
+	if err != nil {
+		return 0, fmt.Errorf("failed to restore dump: %w: %s", err, strings.TrimSpace(output))
+	}
+	return database.CountTables(db, verifyDatabase)
+}
@@ -0,0 +1,161 @@
+package ca
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/utils"
+	"github.com/urfave/cli/v2"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+func Command() *cli.Command {
+	return &cli.Command{
+		Name:  "ca",
+		Usage: "Export or trust the Caddy internal certificate authority",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "export",
+				Usage: "Write the root certificate as PEM and DER, to import in browsers or devices",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Usage:   "Output path without extension, .pem and .der being added",
+					},
+				},
+				Action: exportAction,
+			},
+			{
+				Name:   "install",
+				Usage:  "Add the root certificate to the system trust store (requires root)",
+				Action: installAction,
+			},
+		},
+	}
+}
+
+const configPath = "multipress.yaml"
+
+// trustStoreDir is read by update-ca-certificates on Debian and Ubuntu
+const trustStoreDir = "/usr/local/share/ca-certificates"
+
+func exportAction(c *cli.Context) error {
+	cfg, err := loadInternalConfig()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	cert, err := RootCertificate(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	output := c.String("output")
+	if output == "" {
+		output = cfg.Project + "-ca"
+	}
+
+	pemPath, derPath := output+".pem", output+".der"
+	if err := os.WriteFile(pemPath, encodePem(cert), 0644); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	if err := os.WriteFile(derPath, cert.Raw, 0644); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	fmt.Printf("Root certificate %q (expires %s) written to %s and %s\n", cert.Subject.CommonName, cert.NotAfter.Format("2006-01-02"), pemPath, derPath)
+	return nil
+}
+
+func installAction(c *cli.Context) error {
+	cfg, err := loadInternalConfig()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	if err := Install(cfg); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	fmt.Printf("Root certificate installed in %s, restart browsers to apply it\n", TrustStorePath(cfg))
+	return nil
+}
+
+func loadInternalConfig() (*config.Config, error) {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Caddy == nil {
+		return nil, errors.New("caddy is not configured, run 'multipress deploy' first")
+	}
+	if !cfg.Caddy.IsInternal() {
+		return nil, fmt.Errorf("caddy uses the %s issuer, the internal authority is not used", cfg.Caddy.TLSIssuer)
+	}
+	return cfg, nil
+}
+
+// RootCertificate reads the root certificate of the internal authority from the Caddy container
+func RootCertificate(cfg *config.Config) (*x509.Certificate, error) {
+	content, err := utils.ReadDockerContainerFile(cfg.CaddyContainerName(), config.CaddyInternalRootPath)
+	if err != nil {
+		return nil, fmt.Errorf("root certificate not found, is caddy deployed and serving a site? %w", err)
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("invalid root certificate: no PEM certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// TrustStorePath returns the file holding the root certificate in the system trust store
+func TrustStorePath(cfg *config.Config) string {
+	return filepath.Join(trustStoreDir, cfg.Project+"-multipress-ca.crt")
+}
+
+// Install adds the root certificate to the system trust store, replacing a previous one of the project
+func Install(cfg *config.Config) error {
+	cert, err := RootCertificate(cfg)
+	if err != nil {
+		return err
+	}
+
+	if err := utils.CreateDirectoryIfNotExists(trustStoreDir); err != nil {
+		return err
+	}
+	if err := os.WriteFile(TrustStorePath(cfg), encodePem(cert), 0644); err != nil {
+		return fmt.Errorf("failed to write root certificate, run as root: %w", err)
+	}
+
+	cmd := exec.Command("update-ca-certificates")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return errors.Join(err, errors.New(string(output)))
+	}
+	return nil
+}
+
+// IsTrusted reports whether the root certificate is trusted by the system
+func IsTrusted(cert *x509.Certificate) bool {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		return false
+	}
+	_, err = cert.Verify(x509.VerifyOptions{Roots: pool})
+	return err == nil
+}
+
+func encodePem(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
@@ -2,27 +2,69 @@ package cmd
 
 import (
 	"github.com/quix-labs/multipress/cmd/backup"
+	"github.com/quix-labs/multipress/cmd/ca"
+	"github.com/quix-labs/multipress/cmd/daemon"
+	"github.com/quix-labs/multipress/cmd/db"
 	"github.com/quix-labs/multipress/cmd/deploy"
+	"github.com/quix-labs/multipress/cmd/destroy"
 	"github.com/quix-labs/multipress/cmd/doctor"
 	"github.com/quix-labs/multipress/cmd/down"
+	"github.com/quix-labs/multipress/cmd/expire"
+	"github.com/quix-labs/multipress/cmd/hibernate"
+	"github.com/quix-labs/multipress/cmd/hosts"
+	"github.com/quix-labs/multipress/cmd/images"
+	"github.com/quix-labs/multipress/cmd/instance"
+	"github.com/quix-labs/multipress/cmd/list"
+	"github.com/quix-labs/multipress/cmd/logs"
+	"github.com/quix-labs/multipress/cmd/mail"
 	newcmd "github.com/quix-labs/multipress/cmd/new"
+	"github.com/quix-labs/multipress/cmd/notify"
 	"github.com/quix-labs/multipress/cmd/replicate"
+	"github.com/quix-labs/multipress/cmd/restart"
+	"github.com/quix-labs/multipress/cmd/restore"
+	"github.com/quix-labs/multipress/cmd/rotate"
+	"github.com/quix-labs/multipress/cmd/shell"
+	"github.com/quix-labs/multipress/cmd/status"
 	"github.com/quix-labs/multipress/cmd/up"
+	"github.com/quix-labs/multipress/cmd/wake"
+	"github.com/quix-labs/multipress/cmd/wp"
+	"github.com/quix-labs/multipress/utils"
 	"github.com/urfave/cli/v2"
 	"os"
 )
 
 func Run() error {
 	app := cli.App{
-		Usage: "Generate and replicate Wordpress onto multiple instances",
+		Usage:   "Generate and replicate Wordpress onto multiple instances",
+		Version: utils.Version(),
 		Commands: []*cli.Command{
 			backup.Command(),
+			restore.Command(),
 			down.Command(),
 			up.Command(),
+			restart.Command(),
 			deploy.Command(),
 			doctor.Command(),
 			newcmd.Command(),
 			replicate.Command(),
+			logs.Command(),
+			wp.Command(),
+			shell.Command(),
+			db.Command(),
+			hibernate.Command(),
+			wake.Command(),
+			expire.Command(),
+			instance.Command(),
+			status.Command(),
+			list.Command(),
+			rotate.Command(),
+			notify.Command(),
+			mail.Command(),
+			images.Command(),
+			ca.Command(),
+			destroy.Command(),
+			hosts.Command(),
+			daemon.Command(),
 		},
 	}
 
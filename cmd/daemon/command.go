@@ -0,0 +1,324 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/quix-labs/multipress/cmd/backup"
+	"github.com/quix-labs/multipress/cmd/expire"
+	"github.com/quix-labs/multipress/cmd/hibernate"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/utils"
+	"github.com/robfig/cron/v3"
+	"github.com/urfave/cli/v2"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+func Command() *cli.Command {
+	return &cli.Command{
+		Name:  "daemon",
+		Usage: "Run backups, prune, expiry, health checks and hibernation on the schedule of multipress.yaml",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "run",
+				Usage: fmt.Sprintf("Run a job once now and record it in the history (%s)", strings.Join(jobNames(), ", ")),
+			},
+		},
+		Action: action,
+	}
+}
+
+const configPath = "multipress.yaml"
+
+type job struct {
+	Name string
+	Spec func(s *config.ScheduleConfig) string
+	Run  func(c *cli.Context, cfg *config.Config, run *JobRun) error
+}
+
+var jobs = []job{
+	{"backup", func(s *config.ScheduleConfig) string { return s.Backup }, runBackup},
+	{"prune", func(s *config.ScheduleConfig) string { return s.Prune }, runPrune},
+	{"expire", func(s *config.ScheduleConfig) string { return s.Expire }, runExpire},
+	{"health", func(s *config.ScheduleConfig) string { return s.Health }, runHealth},
+	{"hibernate", func(s *config.ScheduleConfig) string { return s.Hibernate }, runHibernate},
+}
+
+func jobNames() []string {
+	names := make([]string, len(jobs))
+	for i, j := range jobs {
+		names[i] = j.Name
+	}
+	return names
+}
+
+// ScheduledJob is a job with its cron expression, empty when disabled
+type ScheduledJob struct {
+	Name string
+	Spec string
+}
+
+func ScheduledJobs(schedule *config.ScheduleConfig) []ScheduledJob {
+	scheduled := make([]ScheduledJob, len(jobs))
+	for i, j := range jobs {
+		scheduled[i] = ScheduledJob{Name: j.Name, Spec: j.Spec(schedule)}
+	}
+	return scheduled
+}
+
+// NextRun returns the next time a cron expression fires after now
+func NextRun(spec string, now time.Time) (time.Time, error) {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return schedule.Next(now), nil
+}
+
+func action(c *cli.Context) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	if cfg.Schedule == nil {
+		cfg.Schedule = config.NewDefaultScheduleConfig()
+		if err := cfg.SaveAs(configPath); err != nil {
+			fmt.Println(err)
+			return err
+		}
+	}
+
+	if name := c.String("run"); name != "" {
+		index := slices.IndexFunc(jobs, func(j job) bool { return j.Name == name })
+		if index < 0 {
+			err := fmt.Errorf("unknown job %q, expected one of %s", name, strings.Join(jobNames(), ", "))
+			fmt.Println(err)
+			return err
+		}
+		return execute(c, jobs[index])
+	}
+
+	scheduler := cron.New()
+	t := table.NewWriter()
+	t.SetStyle(table.StyleLight)
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Job", "Schedule", "Next run"})
+	for _, j := range jobs {
+		spec := j.Spec(cfg.Schedule)
+		if spec == "" {
+			t.AppendRow(table.Row{j.Name, "disabled", ""})
+			continue
+		}
+		if _, err := scheduler.AddFunc(spec, func() { _ = execute(c, j) }); err != nil {
+			err = fmt.Errorf("invalid schedule.%s %q: %w", j.Name, spec, err)
+			fmt.Println(err)
+			return err
+		}
+		next, _ := NextRun(spec, time.Now())
+		t.AppendRow(table.Row{j.Name, spec, next.Format(time.DateTime)})
+	}
+	t.Render()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	scheduler.Start()
+	fmt.Println("Daemon started, changes of schedule need a restart")
+	<-ctx.Done()
+
+	fmt.Println("Stopping, waiting for running jobs...")
+	<-scheduler.Stop().Done()
+	return nil
+}
+
+// runLock prevents overlapping jobs, which share configuration and containers
+var runLock sync.Mutex
+
+// execute runs a job on a fresh configuration, instances changing between runs, then records it
+func execute(c *cli.Context, j job) error {
+	runLock.Lock()
+	defer runLock.Unlock()
+
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	utils.PrintSeparator(fmt.Sprintf("%s - %s", time.Now().Format(time.DateTime), j.Name), '═')
+	run := JobRun{Job: j.Name, Start: time.Now()}
+	err = j.Run(c, cfg, &run)
+	run.End = time.Now()
+	if err != nil {
+		run.Error = err.Error()
+	}
+
+	if err := appendHistory(cfg, run); err != nil {
+		fmt.Println(err)
+	}
+
+	result := "OK"
+	if run.Failed() {
+		result = "FAILED: " + run.Error
+	}
+	fmt.Printf("%s finished in %s, %s. %s\n", j.Name, run.End.Sub(run.Start).Truncate(time.Second), result, run.Summary)
+	return err
+}
+
+func runBackup(c *cli.Context, cfg *config.Config, run *JobRun) error {
+	identifiers := cfg.InstanceIdentifiers()
+	if len(identifiers) == 0 {
+		run.Summary = "no instances"
+		return nil
+	}
+
+	date, results, err := backup.Run(c, cfg, identifiers)
+	if results == nil {
+		return err
+	}
+	uploadErr := backup.UploadTargets(c, cfg)
+
+	run.Instances = make(map[string]InstanceRun, len(results))
+	var size, stored int64
+	failed := 0
+	for identifier, result := range results {
+		instanceRun := InstanceRun{Size: result.Size}
+		if result.Err != nil {
+			instanceRun.Error = result.Err.Error()
+			failed++
+		}
+		run.Instances[identifier] = instanceRun
+		size += result.Size
+		stored += result.Stored
+	}
+
+	run.Summary = fmt.Sprintf("%d/%d instance(s) backed up in %s, %s (%s added to the store)", len(results)-failed, len(results), date.Format(time.DateTime), utils.FormatBytes(size), utils.FormatBytes(stored))
+	if err != nil {
+		return errors.Join(err, uploadErr)
+	}
+	if failed > 0 {
+		return errors.Join(fmt.Errorf("%d instance(s) failed", failed), uploadErr)
+	}
+	return uploadErr
+}
+
+func runPrune(c *cli.Context, cfg *config.Config, run *JobRun) error {
+	removed, freed, err := backup.Prune(cfg, cfg.Schedule.KeepBackups)
+	run.Summary = fmt.Sprintf("%d backup(s) removed, %d kept, %s freed", len(removed), cfg.Schedule.KeepBackups, utils.FormatBytes(freed))
+	return err
+}
+
+func runExpire(c *cli.Context, cfg *config.Config, run *JobRun) error {
+	expired := expire.Expired(cfg, time.Now())
+	if len(expired) == 0 {
+		run.Summary = "no expired instances"
+		return nil
+	}
+
+	run.Instances = make(map[string]InstanceRun, len(expired))
+	for _, identifier := range expired {
+		run.Instances[identifier] = InstanceRun{Error: "kept, backup failed"}
+	}
+
+	destroyed := expired
+	if cfg.Schedule.ExpireBackup {
+		var err error
+		if destroyed, err = expire.BackupBeforeDestroy(c, cfg, expired); err != nil {
+			return err
+		}
+	}
+	for _, identifier := range destroyed {
+		run.Instances[identifier] = InstanceRun{}
+	}
+
+	run.Summary = fmt.Sprintf("%d/%d expired instance(s) destroyed", len(destroyed), len(expired))
+	if err := expire.Destroy(c, cfg, destroyed); err != nil {
+		return err
+	}
+	if len(destroyed) < len(expired) {
+		return fmt.Errorf("%d instance(s) kept, backup failed", len(expired)-len(destroyed))
+	}
+	return nil
+}
+
+func runHealth(c *cli.Context, cfg *config.Config, run *JobRun) error {
+	hibernated, err := hibernate.Hibernated(cfg)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	for _, service := range cfg.Services() {
+		containerName, err := cfg.ServiceContainerName(service)
+		if err != nil {
+			return err
+		}
+		running, health, err := utils.DockerContainerState(containerName)
+		if err != nil {
+			continue // Not deployed, e.g. optional services
+		}
+		if problem := containerProblem(running, health); problem != "" {
+			problems = append(problems, service+" "+problem)
+		}
+	}
+
+	identifiers := cfg.InstanceIdentifiers()
+	run.Instances = make(map[string]InstanceRun, len(identifiers))
+	unhealthy := 0
+	for _, identifier := range identifiers {
+		var instanceRun InstanceRun
+		running, health, err := utils.DockerContainerState(cfg.InstanceContainerName(identifier))
+		switch {
+		case err != nil:
+			instanceRun.Error = "not deployed"
+		case !running && hibernated[identifier]:
+			// Stopped on purpose, woken up on next request
+		default:
+			instanceRun.Error = containerProblem(running, health)
+		}
+		if instanceRun.Error != "" {
+			unhealthy++
+		}
+		run.Instances[identifier] = instanceRun
+	}
+
+	run.Summary = fmt.Sprintf("%d service problem(s), %d/%d instance(s) unhealthy", len(problems), unhealthy, len(identifiers))
+	if unhealthy > 0 {
+		problems = append(problems, fmt.Sprintf("%d instance(s) unhealthy", unhealthy))
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+func containerProblem(running bool, health string) string {
+	switch {
+	case !running:
+		return "stopped"
+	case health == "unhealthy":
+		return "unhealthy"
+	}
+	return ""
+}
+
+func runHibernate(c *cli.Context, cfg *config.Config, run *JobRun) error {
+	hibernated, unmeasured, err := hibernate.Run(c, cfg)
+	run.Summary = fmt.Sprintf("%d instance(s) hibernated, %d not measured", len(hibernated), len(unmeasured))
+	if len(unmeasured) > 0 {
+		run.Instances = make(map[string]InstanceRun, len(unmeasured))
+		for identifier, unmeasuredErr := range unmeasured {
+			run.Instances[identifier] = InstanceRun{Error: unmeasuredErr.Error()}
+		}
+	}
+	return err
+}
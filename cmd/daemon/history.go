@@ -0,0 +1,78 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/utils"
+	"os"
+	"time"
+)
+
+// historySize is the number of runs kept in the state file
+const historySize = 200
+
+// JobRun is a run of a scheduled job, persisted in the history
+type JobRun struct {
+	Job     string    `json:"job"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Error   string    `json:"error,omitempty"`
+	Summary string    `json:"summary,omitempty"`
+	// Instances holds per-instance results of jobs acting on instances
+	Instances map[string]InstanceRun `json:"instances,omitempty"`
+}
+
+type InstanceRun struct {
+	Error string `json:"error,omitempty"`
+	// Size is the backup archive size in bytes
+	Size int64 `json:"size,omitempty"`
+}
+
+func (r JobRun) Failed() bool {
+	return r.Error != ""
+}
+
+// LoadHistory returns runs from oldest to newest, empty when the daemon never ran
+func LoadHistory(cfg *config.Config) ([]JobRun, error) {
+	if !utils.FileExists(cfg.DaemonStatePath()) {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(cfg.DaemonStatePath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read daemon state: %w", err)
+	}
+	var runs []JobRun
+	if err := json.Unmarshal(data, &runs); err != nil {
+		return nil, fmt.Errorf("failed to parse daemon state: %w", err)
+	}
+	return runs, nil
+}
+
+// LastRuns returns the most recent run of each job
+func LastRuns(runs []JobRun) map[string]JobRun {
+	last := make(map[string]JobRun)
+	for _, run := range runs {
+		last[run.Job] = run
+	}
+	return last
+}
+
+func appendHistory(cfg *config.Config, run JobRun) error {
+	runs, err := LoadHistory(cfg)
+	if err != nil {
+		return err
+	}
+
+	runs = append(runs, run)
+	if len(runs) > historySize {
+		runs = runs[len(runs)-historySize:]
+	}
+
+	data, err := json.MarshalIndent(runs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal daemon state: %w", err)
+	}
+	return utils.WriteFileAtomic(cfg.DaemonStatePath(), data, 0644)
+}
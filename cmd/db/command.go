@@ -0,0 +1,62 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/database"
+	"github.com/quix-labs/multipress/utils"
+	"github.com/urfave/cli/v2"
+)
+
+func Command() *cli.Command {
+	return &cli.Command{
+		Name:      "db",
+		Usage:     "Open a MySQL console on an instance database",
+		ArgsUsage: "<identifier|model>",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "root",
+				Usage: "Connect as MySQL root user instead of the instance user",
+			},
+		},
+		Action: action,
+	}
+}
+
+func action(c *cli.Context) error {
+	configPath := "multipress.yaml"
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	if c.Args().Len() != 1 {
+		fmt.Println("Usage: db <identifier|model>")
+		return errors.New("invalid argument")
+	}
+
+	credentials, err := cfg.TargetCredentials(c.Args().First())
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	dialect, err := database.DialectOf(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	user, password := credentials.DBUser, credentials.DBPassword
+	if c.Bool("root") {
+		user, password = "root", cfg.MySql.RootPassword
+	}
+
+	return utils.InteractiveDockerCmd(cfg.MysqlContainerName(), container.ExecOptions{
+		Env: []string{fmt.Sprintf(`MYSQL_PWD=%s`, password)},
+		Cmd: dialect.ClientCommand(user, credentials.DBName),
+	})
+}
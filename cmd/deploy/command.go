@@ -1,25 +1,25 @@
 package deploy
 
 import (
-	"database/sql"
 	_ "embed"
 	"errors"
 	"fmt"
 	"github.com/docker/docker/api/types/container"
-	"github.com/go-sql-driver/mysql"
 	"github.com/manifoldco/promptui"
 	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/database"
+	"github.com/quix-labs/multipress/hostsfile"
+	"github.com/quix-labs/multipress/proxy"
 	"github.com/quix-labs/multipress/utils"
 	"github.com/urfave/cli/v2"
 	"os"
-	"os/exec"
-	"strings"
+	"path/filepath"
 )
 
 func Command() *cli.Command {
 	return &cli.Command{
 		Name:   "deploy",
-		Usage:  "Deploy Network + Mysql + Model",
+		Usage:  "Deploy Network + Mysql + Mail + Redis + Model",
 		Args:   false,
 		Action: action,
 	}
@@ -39,10 +39,23 @@ var steps = []Step{
 	{"Configuring MySql", configureMySql},
 	{"Creating Volume Directory", createVolumesDirectory},
 	{"Create MySql Volume", createMysqlVolume},
+	{"Configuring phpMyAdmin access", configurePhpMyAdmin},
 	{"Deploying MySql", deployMysql},
+	{"Configuring Mail", configureMail},
+	{"Configuring Mail access", configureMailAccess},
+	{"Create Mail Volume", createMailVolume},
+	{"Deploying Mail", deployMail},
+	{"Configuring Redis", configureRedis},
+	{"Deploying Redis", deployRedis},
+	{"Configuring PHP", configurePhp},
+	{"Vendoring wp-cli", vendorWpCli},
+	{"Writing WordPress Dockerfile", writeWordpressDockerfile},
 	{"Configuring Model", configureModel},
 	{"Create Model Volume", createModelVolume},
 	{"Deploying Model", deployModel},
+	{"Installing Mail plugin", installMailPlugin},
+	{"Applying Redis object cache", applyObjectCache},
+	{"Updating hosts file", updateHostsFile},
 }
 
 func action(c *cli.Context) error {
@@ -66,23 +79,43 @@ func action(c *cli.Context) error {
 	fmt.Printf("URL: %s/wp-admin/\n", cfg.ModelUrl())
 	fmt.Printf("User: %s\n", cfg.Model.Credentials.Username)
 	fmt.Printf("Password: %s\n", cfg.Model.Credentials.Password)
+	if cfg.PhpMyAdmin.Enabled {
+		fmt.Printf("phpMyAdmin: %s (%s / %s)\n", cfg.PhpMyAdminUrl(), cfg.PhpMyAdmin.Username, cfg.PhpMyAdmin.Password)
+	}
+	if cfg.Mail.UiEnabled() {
+		fmt.Printf("Mails: %s (%s / %s)\n", cfg.MailUrl(), cfg.Mail.Access.Username, cfg.Mail.Access.Password)
+	}
 	utils.PrintSeparator("", '═')
 
 	return nil
 }
 
+func updateHostsFile(c *cli.Context, cfg *config.Config) error {
+	return hostsfile.Sync(cfg)
+}
+
 func configureCaddy(c *cli.Context, cfg *config.Config) error {
 	if cfg.Caddy != nil {
 		return utils.SkippedError{Msg: "Configuration already defined"}
 	}
 
 	cfg.Caddy = config.NewDefaultCaddyConfig()
+	proxyPrompt := promptui.Select{
+		Label: "Select Caddy proxy",
+		Items: []string{"project (ports 80/443 used by this project only)", "shared (with other multipress projects of this host)"},
+	}
+	index, _, err := proxyPrompt.Run()
+	if err != nil {
+		fmt.Printf("Prompt failed %v\n", err)
+		return err
+	}
+	cfg.Caddy.Shared = index == 1
+
 	prompt := promptui.Select{
 		Label: "Select TLS Provider",
-		Items: []string{"internal", "acme"},
+		Items: []string{config.TLSIssuerInternal, config.TLSIssuerAcme, config.TLSIssuerFiles},
 	}
 
-	var err error
 	_, cfg.Caddy.TLSIssuer, err = prompt.Run()
 
 	if err != nil {
@@ -90,20 +123,132 @@ func configureCaddy(c *cli.Context, cfg *config.Config) error {
 		return err
 	}
 
+	switch cfg.Caddy.TLSIssuer {
+	case config.TLSIssuerFiles:
+		err = promptCertificateFiles(cfg.Caddy)
+	case config.TLSIssuerAcme:
+		err = promptAcme(cfg.Caddy)
+	}
+	if err != nil {
+		fmt.Printf("Prompt failed %v\n", err)
+		return err
+	}
+
 	return cfg.SaveAs(configPath)
 }
 
+func promptCertificateFiles(caddyCfg *config.CaddyConfig) error {
+	validate := func(s string) error {
+		if !utils.FileExists(s) {
+			return fmt.Errorf("file not found: %s", s)
+		}
+		return nil
+	}
+
+	var err error
+	prompt := promptui.Prompt{Label: "Certificate file (PEM, e.g. wildcard *.domain)", Validate: validate}
+	if caddyCfg.CertFile, err = prompt.Run(); err != nil {
+		return err
+	}
+	prompt = promptui.Prompt{Label: "Private key file (PEM)", Validate: validate}
+	if caddyCfg.KeyFile, err = prompt.Run(); err != nil {
+		return err
+	}
+
+	// Mounted by compose, relative paths would be taken for volume names
+	if caddyCfg.CertFile, err = filepath.Abs(caddyCfg.CertFile); err != nil {
+		return err
+	}
+	caddyCfg.KeyFile, err = filepath.Abs(caddyCfg.KeyFile)
+	return err
+}
+
+func promptAcme(caddyCfg *config.CaddyConfig) error {
+	var err error
+	prompt := promptui.Prompt{Label: "ACME account email (optional)"}
+	if caddyCfg.AcmeEmail, err = prompt.Run(); err != nil {
+		return err
+	}
+
+	challenges := []string{"http"}
+	for _, provider := range config.DnsProviders {
+		challenges = append(challenges, "dns "+provider.Name)
+	}
+	selectPrompt := promptui.Select{
+		Label: "Select ACME challenge (DNS allows a single wildcard certificate)",
+		Items: challenges,
+	}
+	index, _, err := selectPrompt.Run()
+	if err != nil || index == 0 {
+		return err
+	}
+
+	provider := config.DnsProviders[index-1]
+	caddyCfg.DnsProvider, caddyCfg.DnsProviderArgs = provider.Name, provider.Args
+	versionPrompt := promptui.Prompt{
+		Label: fmt.Sprintf("%s version to build Caddy with (release tag, e.g. v0.2.1)", caddyCfg.DnsModulePath()),
+		Validate: func(input string) error {
+			if input == "" {
+				return errors.New("version is required")
+			}
+			return nil
+		},
+	}
+	if caddyCfg.DnsProviderVersion, err = versionPrompt.Run(); err != nil {
+		return err
+	}
+	caddyCfg.DnsEnv = make(map[string]string, len(provider.Env))
+	for _, key := range provider.Env {
+		prompt := promptui.Prompt{Label: key, Mask: '*'}
+		if caddyCfg.DnsEnv[key], err = prompt.Run(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func configureMySql(c *cli.Context, cfg *config.Config) error {
 	if cfg.MySql != nil {
 		return utils.SkippedError{Msg: "Configuration already defined"}
 	}
 
 	cfg.MySql = config.NewDefaultMysqlConfig()
-	// You can ask here, but for simplicity, keep default auto-generated
+	prompt := promptui.Select{
+		Label: "Select database engine",
+		Items: database.Engines,
+	}
+
+	var err error
+	if _, cfg.MySql.Engine, err = prompt.Run(); err != nil {
+		fmt.Printf("Prompt failed %v\n", err)
+		return err
+	}
+
+	// Image follows the engine unless customized, MySQL is not deployed yet so pinning is safe
+	if image, exists := cfg.Images["mysql"]; !exists || image == config.DefaultEngineImage(config.EngineMysql) {
+		if cfg.Images == nil {
+			cfg.Images = make(map[string]config.ImageConfig)
+		}
+		cfg.Images["mysql"] = config.DefaultEngineImage(cfg.MySql.Engine)
+	}
 	return cfg.SaveAs(configPath)
 
 }
 
+func configurePhpMyAdmin(c *cli.Context, cfg *config.Config) error {
+	if cfg.PhpMyAdmin == nil {
+		cfg.PhpMyAdmin = config.NewDefaultAccessConfig()
+	}
+	changed, err := cfg.PhpMyAdmin.EnsurePasswordHash()
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return utils.SkippedError{Msg: "Configuration already defined"}
+	}
+	return cfg.SaveAs(configPath)
+}
+
 func configureModel(c *cli.Context, cfg *config.Config) error {
 	if cfg.Model != nil {
 		return utils.SkippedError{Msg: "Configuration already defined"}
@@ -135,35 +280,34 @@ func createVolumesDirectory(c *cli.Context, cfg *config.Config) error {
 }
 
 func createDockerNetworkIfNotExists(c *cli.Context, cfg *config.Config) error {
-	checkCmd := exec.Command("docker", "network", "ls", "--filter", fmt.Sprintf("name=%s", cfg.NetworkName()), "--format", "{{.Name}}")
-	existingNetworks, err := checkCmd.Output()
-	if err != nil {
-		return errors.New("failed to check existing networks: " + err.Error())
-	}
-	if strings.TrimSpace(string(existingNetworks)) == cfg.NetworkName() {
-		return utils.SkippedError{Msg: "Network already exists"}
-	}
+	return utils.CreateDockerNetworkIfNotExists(cfg.NetworkName())
+}
 
-	output, err := exec.Command("docker", "network", "create", cfg.NetworkName()).CombinedOutput()
-	if err != nil {
-		return errors.Join(err, errors.New(string(output)))
+func deployCaddy(c *cli.Context, cfg *config.Config) error {
+	if cfg.Caddy.TLSIssuer == config.TLSIssuerFiles {
+		for _, file := range []string{cfg.Caddy.CertFile, cfg.Caddy.KeyFile} {
+			if !filepath.IsAbs(file) || !utils.FileExists(file) {
+				return fmt.Errorf("certificate file must be an existing absolute path: %q", file)
+			}
+		}
 	}
-	return nil
-}
 
-//go:embed tmpl/caddy.yaml.tmpl
-var caddyTmpl string
+	if cfg.Caddy.DnsProvider != "" && cfg.Caddy.DnsProviderVersion == "" {
+		return fmt.Errorf("caddy.dns-provider-version must pin %s, e.g. to its latest release tag", cfg.Caddy.DnsModulePath())
+	}
 
-func deployCaddy(c *cli.Context, cfg *config.Config) error {
-	if err := utils.ParseTemplateToFile(caddyTmpl, cfg, "compose.caddy.yaml"); err != nil {
-		return err
+	if cfg.Caddy.Shared {
+		// The project Caddy would hold ports of the shared proxy
+		if err := proxy.RemoveProject(cfg); err != nil {
+			return err
+		}
+		return proxy.Register(cfg)
 	}
 
-	if _, err := utils.UpComposeFile("compose.caddy.yaml"); err != nil {
+	if err := proxy.Unregister(cfg.Project); err != nil && !errors.As(err, &utils.SkippedError{}) {
 		return err
 	}
-
-	return nil
+	return proxy.DeployProject(cfg)
 }
 
 func createMysqlVolume(c *cli.Context, cfg *config.Config) error {
@@ -174,12 +318,26 @@ func createMysqlVolume(c *cli.Context, cfg *config.Config) error {
 //go:embed tmpl/mysql.yaml.tmpl
 var mysqlTmpl string
 
+type MysqlTmplData struct {
+	*config.Config
+	Dialect database.Dialect
+}
+
+// WriteMysqlCompose renders the MySQL compose file from the configuration
+func WriteMysqlCompose(cfg *config.Config) error {
+	dialect, err := database.DialectOf(cfg)
+	if err != nil {
+		return err
+	}
+	return utils.ParseTemplateToFile(mysqlTmpl, MysqlTmplData{Config: cfg, Dialect: dialect}, cfg.MysqlComposePath())
+}
+
 func deployMysql(c *cli.Context, cfg *config.Config) error {
-	if err := utils.ParseTemplateToFile(mysqlTmpl, cfg, "compose.mysql.yaml"); err != nil {
+	if err := WriteMysqlCompose(cfg); err != nil {
 		return err
 	}
 
-	if _, err := utils.UpComposeFile("compose.mysql.yaml"); err != nil {
+	if _, err := utils.UpComposeFile(cfg.MysqlComposePath()); err != nil {
 		return err
 	}
 
@@ -194,8 +352,13 @@ func createModelVolume(c *cli.Context, cfg *config.Config) error {
 //go:embed tmpl/model.yaml.tmpl
 var modelTmpl string
 
+// WriteModelCompose renders the model compose file from the configuration
+func WriteModelCompose(cfg *config.Config) error {
+	return utils.ParseTemplateToFile(modelTmpl, cfg, cfg.ModelComposePath())
+}
+
 func deployModel(c *cli.Context, cfg *config.Config) error {
-	if err := utils.ParseTemplateToFile(modelTmpl, cfg, "compose.model.yaml"); err != nil {
+	if err := WriteModelCompose(cfg); err != nil {
 		return err
 	}
 
@@ -203,7 +366,12 @@ func deployModel(c *cli.Context, cfg *config.Config) error {
 		return err
 	}
 
-	if _, err := utils.UpComposeFile("compose.model.yaml"); err != nil {
+	// Up only builds a missing image, rebuild to apply PHP changes
+	if _, err := utils.BuildComposeFile(cfg.ModelComposePath()); err != nil {
+		return err
+	}
+
+	if _, err := utils.UpComposeFile(cfg.ModelComposePath()); err != nil {
 		return err
 	}
 
@@ -215,37 +383,19 @@ func deployModel(c *cli.Context, cfg *config.Config) error {
 }
 
 func bootstrapDatabaseCredentials(cfg *config.Config) error {
-	credentials := &cfg.Model.Credentials
-
-	mysqlIP, err := utils.GetDockerContainerIP(cfg.MysqlContainerName())
+	dialect, err := database.DialectOf(cfg)
 	if err != nil {
 		return err
 	}
 
-	mysqlConnector, err := mysql.NewConnector(&mysql.Config{User: "root", Passwd: cfg.MySql.RootPassword, Addr: mysqlIP})
+	db, err := database.Connect(cfg, "")
 	if err != nil {
-		return fmt.Errorf("failed to connect to MySQL: %w", err)
+		return err
 	}
-	db := sql.OpenDB(mysqlConnector)
 	defer db.Close()
 
-	statements := []string{
-		fmt.Sprintf("DROP DATABASE IF EXISTS `%s`", credentials.DBName),
-		fmt.Sprintf("DROP USER IF EXISTS '%s'@'%%'", credentials.DBUser),
-		fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s`", credentials.DBName),
-		fmt.Sprintf("CREATE USER IF NOT EXISTS '%s'@'%%' IDENTIFIED BY '%s'", credentials.DBUser, credentials.DBPassword),
-		fmt.Sprintf("GRANT ALL PRIVILEGES ON `%s`.* TO '%s'@'%%'", credentials.DBName, credentials.DBUser),
-		"FLUSH PRIVILEGES",
-	}
-
-	for _, statement := range statements {
-		//fmt.Printf("Executing SQL: %s\n", statement)
-		if _, err := db.Exec(statement); err != nil {
-			return fmt.Errorf("failed to execute statement %q: %w", statement, err)
-		}
-	}
-
-	return nil
+	// Deploy is run again to apply configuration changes, never drop the model
+	return database.ExecStatements(db, dialect.EnsureStatements(cfg.Model.Credentials))
 }
 
 func installWordpress(cfg *config.Config) error {
@@ -254,8 +404,6 @@ func installWordpress(cfg *config.Config) error {
 			"wp core install --url='%s' --title='Mon site Multipress' --admin_user='%s' --admin_email='%s' --admin_password='%s' --skip-email",
 			cfg.ModelUrl(), cfg.Model.Credentials.Username, cfg.Model.Credentials.Email, cfg.Model.Credentials.Password,
 		),
-		`echo 'php_value upload_max_filesize 2048M' >> .htaccess`,
-		`echo 'php_value post_max_size 2048M' >> .htaccess`,
 	}
 
 	for _, command := range installCommands {
@@ -0,0 +1,172 @@
+package deploy
+
+import (
+	_ "embed"
+	"fmt"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/utils"
+	"github.com/urfave/cli/v2"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MailSteps applies the mail configuration, deploying or removing the catcher and updating the mu-plugin everywhere
+var MailSteps = []Step{
+	{"Configuring Mail", configureMail},
+	{"Configuring Mail access", configureMailAccess},
+	{"Create Mail Volume", createMailVolume},
+	{"Deploying Mail", deployMail},
+	{"Installing Mail plugin", installMailPlugin},
+}
+
+func configureMail(c *cli.Context, cfg *config.Config) error {
+	if cfg.Mail != nil {
+		return utils.SkippedError{Msg: "Configuration already defined"}
+	}
+
+	cfg.Mail = config.NewDefaultMailConfig(cfg)
+	// Catcher by default, switch mode to relay in configuration to send real emails
+	return cfg.SaveAs(configPath)
+}
+
+// configureMailAccess protects the catcher interface of configurations created without it
+func configureMailAccess(c *cli.Context, cfg *config.Config) error {
+	if cfg.Mail.Access == nil {
+		cfg.Mail.Access = config.NewDefaultAccessConfig()
+	}
+	changed, err := cfg.Mail.Access.EnsurePasswordHash()
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return utils.SkippedError{Msg: "Configuration already defined"}
+	}
+	return cfg.SaveAs(configPath)
+}
+
+func createMailVolume(c *cli.Context, cfg *config.Config) error {
+	if cfg.Mail.Mode != config.MailModeCatcher {
+		return utils.SkippedError{Msg: "mail catcher disabled"}
+	}
+	return createVolumeDirectory(cfg, cfg.MailVolumePath())
+}
+
+//go:embed tmpl/mail.yaml.tmpl
+var mailTmpl string
+
+func deployMail(c *cli.Context, cfg *config.Config) error {
+	if cfg.Mail.Mode != config.MailModeCatcher {
+		// Remove the catcher previously deployed
+		if !utils.FileExists(cfg.MailComposePath()) {
+			return utils.SkippedError{Msg: "mail catcher disabled"}
+		}
+		if _, err := utils.DownComposeFile(cfg.MailComposePath()); err != nil {
+			return err
+		}
+		return utils.RemoveFile(cfg.MailComposePath())
+	}
+
+	if err := utils.ParseTemplateToFile(mailTmpl, cfg, cfg.MailComposePath()); err != nil {
+		return err
+	}
+
+	if _, err := utils.UpComposeFile(cfg.MailComposePath()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+//go:embed tmpl/mail-plugin.php.tmpl
+var mailPluginTmpl string
+
+// MailPluginTmplData holds values already formatted as PHP literals
+type MailPluginTmplData struct {
+	Host     string
+	Port     int
+	Secure   string
+	AutoTLS  string
+	Auth     bool
+	Username string
+	Password string
+	From     string
+}
+
+const mailPluginPath = "wp-content/mu-plugins/multipress-mail.php"
+
+// installMailPlugin writes the mu-plugin into the model volume, inherited by future replicas, and existing instances volumes
+func installMailPlugin(c *cli.Context, cfg *config.Config) error {
+	volumePaths := []string{cfg.ModelVolumePath()}
+	for _, identifier := range cfg.InstanceIdentifiers() {
+		volumePaths = append(volumePaths, cfg.InstanceVolumePath(identifier))
+	}
+
+	for _, volumePath := range volumePaths {
+		if err := WriteMailPlugin(cfg, volumePath); err != nil {
+			return fmt.Errorf("%s: %w", volumePath, err)
+		}
+	}
+	return nil
+}
+
+// WriteMailPlugin writes the SMTP mu-plugin into a WordPress volume, removing it when mail is disabled
+func WriteMailPlugin(cfg *config.Config, volumePath string) error {
+	pluginPath := filepath.Join(volumePath, mailPluginPath)
+
+	smtp := cfg.MailSmtp()
+	if smtp == nil {
+		if !utils.FileExists(pluginPath) {
+			return nil
+		}
+		return utils.RemoveFile(pluginPath)
+	}
+
+	data := MailPluginTmplData{
+		Host:     phpString(smtp.Host),
+		Port:     smtp.Port,
+		Secure:   phpString(""),
+		AutoTLS:  "false",
+		Auth:     smtp.Username != "",
+		Username: phpString(smtp.Username),
+		Password: phpString(smtp.Password),
+	}
+	switch smtp.Encryption {
+	case "starttls":
+		data.Secure, data.AutoTLS = phpString("tls"), "true"
+	case "tls":
+		data.Secure = phpString("ssl")
+	}
+	if smtp.From != "" {
+		data.From = phpString(smtp.From)
+	}
+
+	// WordPress populates the volume on first start, never create its tree
+	if exists, err := utils.DirectoryExists(filepath.Join(volumePath, "wp-content")); err != nil || !exists {
+		return err
+	}
+
+	pluginDir := filepath.Dir(pluginPath)
+	if err := utils.CreateDirectoryIfNotExists(pluginDir); err != nil {
+		return err
+	}
+	if err := utils.ParseTemplateToFile(mailPluginTmpl, data, pluginPath); err != nil {
+		return err
+	}
+
+	// Relay password is embedded, only readable by WordPress
+	if err := os.Chmod(pluginPath, 0600); err != nil {
+		return err
+	}
+	for _, path := range []string{pluginDir, pluginPath} {
+		if err := os.Chown(path, cfg.Uid, cfg.Gid); err != nil {
+			return fmt.Errorf("failed to change ownership of %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// phpString quotes s as a single-quoted PHP literal, where only \ and ' are escaped
+func phpString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
@@ -0,0 +1,110 @@
+package deploy
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/utils"
+	"github.com/urfave/cli/v2"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const wpCliUrl = "https://raw.githubusercontent.com/wp-cli/builds/gh-pages/phar/wp-cli.phar"
+
+// Header of generated Dockerfiles, deploy keeps files without it
+const dockerfileHeader = "# Generated by multipress"
+
+// legacyDockerfile is the Dockerfile written by new before it was generated from configuration
+const legacyDockerfile = `FROM wordpress:php8.3-apache
+
+RUN apt update && apt install -y less
+RUN curl -O https://raw.githubusercontent.com/wp-cli/builds/gh-pages/phar/wp-cli.phar
+RUN chmod +x wp-cli.phar
+RUN mv wp-cli.phar /usr/local/bin/wp
+
+ENTRYPOINT ["docker-entrypoint.sh"]
+CMD ["apache2-foreground"]`
+
+func configurePhp(c *cli.Context, cfg *config.Config) error {
+	if cfg.Php != nil {
+		return utils.SkippedError{Msg: "Configuration already defined"}
+	}
+
+	cfg.Php = config.NewDefaultPhpConfig()
+	// Same PHP as before it was configurable, edit it in configuration
+	return cfg.SaveAs(configPath)
+}
+
+func vendorWpCli(c *cli.Context, cfg *config.Config) error {
+	wpCliPath := cfg.PhpConfig().WpCli
+	if wpCliPath == "" {
+		return utils.SkippedError{Msg: "downloaded at build time"}
+	}
+	if utils.FileExists(wpCliPath) {
+		return utils.SkippedError{Msg: "already vendored"}
+	}
+
+	resp, err := http.Get(wpCliUrl)
+	if err != nil {
+		return fmt.Errorf("failed to download wp-cli: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download wp-cli: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to download wp-cli: %w", err)
+	}
+	return utils.WriteFileAtomic(wpCliPath, data, 0755)
+}
+
+//go:embed tmpl/wordpress.Dockerfile.tmpl
+var wordpressDockerfileTmpl string
+
+// WriteWordpressDockerfile renders the WordPress image Dockerfile from the php configuration, with the ini file it copies
+func WriteWordpressDockerfile(cfg *config.Config, path string) error {
+	if err := writePhpIni(cfg, filepath.Join(filepath.Dir(path), cfg.PhpIniPath())); err != nil {
+		return err
+	}
+
+	tmpl, err := template.New("").Parse(wordpressDockerfileTmpl)
+	if err != nil {
+		return err
+	}
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, cfg); err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(path, buf.Bytes(), 0644)
+}
+
+// writePhpIni writes ini lines as a file of the build context, any value being copied as is
+func writePhpIni(cfg *config.Config, path string) error {
+	lines := cfg.PhpConfig().IniLines()
+	if len(lines) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return utils.WriteFileAtomic(path, []byte("; Generated by multipress from the php section of multipress.yaml\n"+strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+func writeWordpressDockerfile(c *cli.Context, cfg *config.Config) error {
+	path := cfg.WordpressDockerfilePath()
+	if current, err := os.ReadFile(path); err == nil {
+		content := strings.TrimSpace(string(current))
+		if !strings.HasPrefix(content, dockerfileHeader) && content != legacyDockerfile {
+			return utils.SkippedError{Msg: "customized Dockerfile kept"}
+		}
+	}
+	return WriteWordpressDockerfile(cfg, path)
+}
@@ -0,0 +1,183 @@
+package deploy
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"github.com/quix-labs/multipress/cmd/replicate"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/utils"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/sync/errgroup"
+	"path/filepath"
+)
+
+func configureRedis(c *cli.Context, cfg *config.Config) error {
+	if cfg.Redis != nil {
+		return utils.SkippedError{Msg: "Configuration already defined"}
+	}
+
+	cfg.Redis = config.NewDefaultRedisConfig()
+	// Set enabled to false in configuration to skip the object cache
+	return cfg.SaveAs(configPath)
+}
+
+//go:embed tmpl/redis.yaml.tmpl
+var redisTmpl string
+
+func deployRedis(c *cli.Context, cfg *config.Config) error {
+	if !cfg.RedisEnabled() {
+		if !utils.FileExists(cfg.RedisComposePath()) {
+			return utils.SkippedError{Msg: "object cache disabled"}
+		}
+		if _, err := utils.DownComposeFile(cfg.RedisComposePath()); err != nil {
+			return err
+		}
+		return utils.RemoveFile(cfg.RedisComposePath())
+	}
+
+	if err := utils.ParseTemplateToFile(redisTmpl, cfg, cfg.RedisComposePath()); err != nil {
+		return err
+	}
+
+	if _, err := utils.UpComposeFile(cfg.RedisComposePath()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// applyObjectCache activates redis-cache on the model, inherited by future replicas, and existing instances,
+// or removes it when the object cache is disabled
+func applyObjectCache(c *cli.Context, cfg *config.Config) error {
+	if !cfg.RedisEnabled() {
+		return disableObjectCache(cfg)
+	}
+
+	if err := allocateRedisDatabases(cfg); err != nil {
+		return err
+	}
+
+	if err := activateRedisCache(cfg, cfg.ModelContainerName()); err != nil {
+		return fmt.Errorf("model: %w", err)
+	}
+
+	var g errgroup.Group
+	g.SetLimit(5)
+	identifiers := cfg.InstanceIdentifiers()
+	errs := make([]error, len(identifiers))
+	for i, identifier := range identifiers {
+		g.Go(func() error {
+			if err := enableInstanceObjectCache(cfg, identifier); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", identifier, err)
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+	return errors.Join(errs...)
+}
+
+// allocateRedisDatabases gives its own database to instances replicated before the object cache
+func allocateRedisDatabases(cfg *config.Config) error {
+	changed := false
+	for _, identifier := range cfg.InstanceIdentifiers() {
+		credentials := cfg.Instances.Credentials[identifier]
+		if credentials.RedisDatabase != 0 {
+			continue
+		}
+		database, err := cfg.Instances.NextRedisDatabase()
+		if err != nil {
+			return err
+		}
+		credentials.RedisDatabase = database
+		cfg.Instances.Credentials[identifier] = credentials
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return cfg.SaveAs(configPath)
+}
+
+// disableObjectCache removes the redis-cache drop-in of the model and instances, and their Redis settings,
+// the model compose file being already rewritten by deployModel
+func disableObjectCache(cfg *config.Config) error {
+	removed, err := removeObjectCacheDropIn(cfg.ModelVolumePath())
+	if err != nil {
+		return fmt.Errorf("model: %w", err)
+	}
+
+	identifiers := cfg.InstanceIdentifiers()
+	errs := make([]error, len(identifiers))
+	for i, identifier := range identifiers {
+		instanceRemoved, err := removeObjectCacheDropIn(cfg.InstanceVolumePath(identifier))
+		if err == nil && instanceRemoved {
+			err = disableInstanceObjectCache(cfg, identifier)
+		}
+		if err != nil {
+			errs[i] = fmt.Errorf("%s: %w", identifier, err)
+		}
+		removed = removed || instanceRemoved
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	if !removed {
+		return utils.SkippedError{Msg: "object cache disabled"}
+	}
+	return nil
+}
+
+// removeObjectCacheDropIn deletes the drop-in from a WordPress volume, WordPress then no longer loads redis-cache,
+// 'wp redis update-dropin' copying it again when the object cache is enabled back
+func removeObjectCacheDropIn(volumePath string) (bool, error) {
+	dropIn := filepath.Join(volumePath, "wp-content", "object-cache.php")
+	if !utils.FileExists(dropIn) {
+		return false, nil
+	}
+	return true, utils.RemoveFile(dropIn)
+}
+
+// disableInstanceObjectCache recreates running instances without Redis settings, stopped ones lose them on next up
+func disableInstanceObjectCache(cfg *config.Config, identifier string) error {
+	if err := replicate.WriteInstanceCompose(cfg, identifier); err != nil {
+		return err
+	}
+
+	if running, _, err := utils.DockerContainerState(cfg.InstanceContainerName(identifier)); err != nil || !running {
+		return nil
+	}
+
+	_, err := utils.UpComposeFile(cfg.InstanceComposePath(identifier))
+	return err
+}
+
+// enableInstanceObjectCache recreates running instances with Redis settings, stopped ones get them on next up
+func enableInstanceObjectCache(cfg *config.Config, identifier string) error {
+	if err := replicate.WriteInstanceCompose(cfg, identifier); err != nil {
+		return err
+	}
+
+	containerName := cfg.InstanceContainerName(identifier)
+	if running, _, err := utils.DockerContainerState(containerName); err != nil || !running {
+		return nil
+	}
+
+	if _, err := utils.UpComposeFile(cfg.InstanceComposePath(identifier)); err != nil {
+		return err
+	}
+	return activateRedisCache(cfg, containerName)
+}
+
+func activateRedisCache(cfg *config.Config, containerName string) error {
+	command := "(wp plugin is-installed redis-cache || wp plugin install redis-cache) && wp plugin activate redis-cache && wp redis update-dropin"
+	if res, err := utils.ExecDockerCmd(containerName, container.ExecOptions{
+		User: fmt.Sprintf("%d:%d", cfg.Uid, cfg.Gid),
+		Cmd:  []string{"bash", "-c", command},
+	}, nil); err != nil {
+		return fmt.Errorf("error enabling object cache: %v - Details: %s", err, res)
+	}
+	return nil
+}
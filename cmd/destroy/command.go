@@ -0,0 +1,65 @@
+package destroy
+
+import (
+	"fmt"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/hostsfile"
+	"github.com/quix-labs/multipress/proxy"
+	"github.com/quix-labs/multipress/stack"
+	"github.com/quix-labs/multipress/utils"
+	"github.com/urfave/cli/v2"
+)
+
+func Command() *cli.Command {
+	return &cli.Command{
+		Name:  "destroy",
+		Usage: "Remove all containers and the network of the project, its hosts file block, and unregister it from the shared proxy",
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:    "parallel",
+				Aliases: []string{"p"},
+				Usage:   "Maximum number of instances processed simultaneously",
+				Value:   10,
+			},
+		},
+		Action: action,
+	}
+}
+
+const configPath = "multipress.yaml"
+
+func action(c *cli.Context) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	selection := stack.Selection{Infra: true, Identifiers: cfg.InstanceIdentifiers(), Parallel: c.Int("parallel")}
+	steps := stack.DownSteps(cfg, selection)
+	steps = append(steps,
+		stack.Step{Label: "Unregistering from shared proxy", Run: func() error {
+			return proxy.Unregister(cfg.Project)
+		}},
+		stack.Step{Label: "Removing hosts file block", Run: func() error {
+			if cfg.Hosts == nil || cfg.Hosts.File == "" {
+				return utils.SkippedError{Msg: "hosts file not managed"}
+			}
+			return hostsfile.Remove(cfg.Project, cfg.Hosts.File)
+		}},
+		stack.Step{Label: "Removing Docker network", Run: func() error {
+			return utils.RemoveDockerNetworkIfExists(cfg.NetworkName())
+		}},
+	)
+
+	utils.PrintSeparator("Destroy", '═')
+	for _, step := range steps {
+		if err := utils.Spin(utils.SpinOptions{Label: step.Label}, step.Run); err != nil {
+			return err
+		}
+	}
+
+	utils.PrintSeparator("Project destroyed", '═')
+	fmt.Println("Volumes, backups and configuration are kept, remove the project directory to delete them")
+	return nil
+}
@@ -5,9 +5,14 @@ import (
 	"fmt"
 	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/jedib0t/go-pretty/v6/text"
+	"github.com/quix-labs/multipress/cmd/ca"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/proxy"
 	"github.com/urfave/cli/v2"
+	"maps"
 	"os"
 	"os/exec"
+	"slices"
 	"strings"
 )
 
@@ -72,6 +77,62 @@ var checks = []*check{
 			return installAptDependencies("zip", "unzip")
 		},
 	},
+	{
+		name:        "Ports",
+		description: "Check ports 80 and 443 free for Caddy, or used by the proxy of the project",
+		check: func(context *cli.Context) (bool, error) {
+			return arePortsAvailable()
+		},
+	},
+	{
+		name:        "Caddy CA",
+		description: "Check internal TLS authority trusted by the system, for projects using it",
+		check: func(context *cli.Context) (bool, error) {
+			return isCaddyCATrusted(), nil
+		},
+		resolve: func(context *cli.Context) error {
+			cfg, err := config.LoadConfig(configPath)
+			if err != nil {
+				return err
+			}
+			return ca.Install(cfg)
+		},
+	},
+}
+
+const configPath = "multipress.yaml"
+
+// details explain failed checks, printed below the table
+var details = make(map[string][]string)
+
+// arePortsAvailable checks ports of Caddy are free or used by the Caddy of the project, shared outside projects
+func arePortsAvailable() (bool, error) {
+	containerName := config.SharedCaddyContainerName
+	if cfg, err := config.LoadConfig(configPath); err == nil {
+		containerName = cfg.CaddyContainerName()
+	}
+
+	conflicts, err := proxy.PortConflicts(containerName)
+	if err != nil {
+		// Reported by the Docker check when not installed
+		details["Ports"] = []string{err.Error()}
+		return false, nil
+	}
+	details["Ports"] = conflicts
+	return len(conflicts) == 0, nil
+}
+
+// isCaddyCATrusted is true outside projects, with another issuer, or while Caddy has not created its authority yet
+func isCaddyCATrusted() bool {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil || cfg.Caddy == nil || !cfg.Caddy.IsInternal() {
+		return true
+	}
+	cert, err := ca.RootCertificate(cfg)
+	if err != nil {
+		return true
+	}
+	return ca.IsTrusted(cert)
 }
 
 func isSupportedOS() bool {
@@ -133,6 +194,12 @@ func action(c *cli.Context) error {
 	}
 	t.Render()
 
+	for _, name := range slices.Sorted(maps.Keys(details)) {
+		for _, line := range details[name] {
+			fmt.Printf("%s: %s\n", name, line)
+		}
+	}
+
 	if c.Bool("dry-run") {
 		return nil
 	}
@@ -0,0 +1,258 @@
+package expire
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+	"github.com/quix-labs/multipress/cmd/backup"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/database"
+	"github.com/quix-labs/multipress/hostsfile"
+	"github.com/quix-labs/multipress/inventory"
+	"github.com/quix-labs/multipress/utils"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/sync/errgroup"
+	"os"
+	"sync"
+	"time"
+)
+
+func Command() *cli.Command {
+	return &cli.Command{
+		Name:  "expire",
+		Usage: "Warn about soon expiring instances and destroy expired ones (run it from cron)",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "warn",
+				Usage: "Warn about instances expiring within this duration",
+				Value: "3d",
+			},
+			&cli.BoolFlag{
+				Name:  "backup",
+				Usage: "Backup expired instances before destroying them",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Only report expired instances, do not destroy them",
+			},
+		},
+		Action: action,
+	}
+}
+
+const configPath = "multipress.yaml"
+
+type InstanceStep struct {
+	Label string
+	Run   func(c *cli.Context, cfg *config.Config, identifier string) error
+}
+
+var steps = []InstanceStep{
+	{"Stopping containers", stopInstance},
+	{"Dropping databases", dropInstanceDatabase},
+	{"Removing volumes", removeInstanceVolume},
+	{"Removing compose files", removeInstanceCompose},
+	{"Removing configuration", removeInstanceConfiguration},
+}
+
+func action(c *cli.Context) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	warn, err := utils.ParseDuration(c.String("warn"))
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	now := time.Now()
+	expired := Expired(cfg, now)
+	var expiring []string
+	for _, identifier := range cfg.InstanceIdentifiers() {
+		expiresAt := cfg.Instances.Metadata[identifier].ExpiresAt
+		if expiresAt.After(now) && expiresAt.Sub(now) <= warn {
+			expiring = append(expiring, identifier)
+		}
+	}
+
+	if len(expiring) > 0 {
+		utils.PrintSeparator("Expiring soon", '═')
+		printInstances(cfg, expiring, now)
+	}
+
+	if len(expired) == 0 {
+		fmt.Println("No expired instances")
+		return nil
+	}
+
+	utils.PrintSeparator("Expired", '═')
+	printInstances(cfg, expired, now)
+	if c.Bool("dry-run") {
+		return nil
+	}
+
+	if !c.Bool("backup") {
+		return Destroy(c, cfg, expired)
+	}
+
+	backedUp, err := BackupBeforeDestroy(c, cfg, expired)
+	if err != nil {
+		return err
+	}
+	if err := Destroy(c, cfg, backedUp); err != nil {
+		return err
+	}
+	if kept := len(expired) - len(backedUp); kept > 0 {
+		err := fmt.Errorf("%d expired instance(s) kept, backup failed", kept)
+		fmt.Println(err)
+		return err
+	}
+	return nil
+}
+
+// Expired returns instances whose expiry date is past
+func Expired(cfg *config.Config, now time.Time) []string {
+	var expired []string
+	for _, identifier := range cfg.InstanceIdentifiers() {
+		expiresAt := cfg.Instances.Metadata[identifier].ExpiresAt
+		if !expiresAt.IsZero() && !expiresAt.After(now) {
+			expired = append(expired, identifier)
+		}
+	}
+	return expired
+}
+
+// BackupBeforeDestroy backups instances, returning the ones safely backed up, others being kept.
+// An instance without result is kept too, only a backup known to succeed allowing to destroy it.
+func BackupBeforeDestroy(c *cli.Context, cfg *config.Config, identifiers []string) ([]string, error) {
+	_, results, err := backup.Run(c, cfg, identifiers)
+	if err != nil {
+		return nil, err
+	}
+	// Backups are complete locally, an offsite copy failing does not keep instances
+	if err := backup.UploadTargets(c, cfg); err != nil {
+		fmt.Println(err)
+	}
+
+	var backedUp []string
+	for _, identifier := range identifiers {
+		result, exists := results[identifier]
+		if !exists {
+			fmt.Printf("%s kept, backup result missing\n", identifier)
+			continue
+		}
+		if result.Err != nil {
+			fmt.Printf("%s kept, backup failed: %v\n", identifier, result.Err)
+			continue
+		}
+		backedUp = append(backedUp, identifier)
+	}
+	return backedUp, nil
+}
+
+// Destroy removes containers, database, volume, compose file and configuration entry of instances
+func Destroy(c *cli.Context, cfg *config.Config, identifiers []string) error {
+	if len(identifiers) == 0 {
+		return nil
+	}
+
+	utils.PrintSeparator("Destroy expired instances", '═')
+	for _, step := range steps {
+		if err := utils.Spin(utils.SpinOptions{Label: step.Label}, func() error {
+			var g errgroup.Group
+			errs := make([]error, len(identifiers))
+			for i, identifier := range identifiers {
+				g.Go(func() error {
+					if err := step.Run(c, cfg, identifier); err != nil && !errors.As(err, &utils.SkippedError{}) {
+						errs[i] = fmt.Errorf("%s: %w", identifier, err)
+					}
+					return nil
+				})
+			}
+			_ = g.Wait()
+			return errors.Join(errs...)
+		}); err != nil {
+			return err
+		}
+	}
+
+	return utils.Spin(utils.SpinOptions{Label: "Updating hosts file"}, func() error {
+		return hostsfile.Sync(cfg)
+	})
+}
+
+func printInstances(cfg *config.Config, identifiers []string, now time.Time) {
+	t := table.NewWriter()
+	t.SetStyle(table.StyleLight)
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Instance", "URL", "Expires at", "Remaining"})
+	for _, identifier := range identifiers {
+		expiresAt := cfg.Instances.Metadata[identifier].ExpiresAt
+		remaining := text.FgRed.Sprint("expired")
+		if expiresAt.After(now) {
+			remaining = text.FgYellow.Sprint(expiresAt.Sub(now).Truncate(time.Minute).String())
+		}
+		t.AppendRow(table.Row{identifier, cfg.InstanceUrl(identifier), expiresAt.Format(time.DateTime), remaining})
+	}
+	t.Render()
+}
+
+func stopInstance(c *cli.Context, cfg *config.Config, identifier string) error {
+	composePath := cfg.InstanceComposePath(identifier)
+	if !utils.FileExists(composePath) {
+		return utils.SkippedError{Msg: "compose file not found"}
+	}
+	_, err := utils.DownComposeFile(composePath)
+	return err
+}
+
+func dropInstanceDatabase(c *cli.Context, cfg *config.Config, identifier string) error {
+	dialect, err := database.DialectOf(cfg)
+	if err != nil {
+		return err
+	}
+
+	db, err := database.Connect(cfg, "")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return database.ExecStatements(db, dialect.DropStatements(cfg.Instances.Credentials[identifier]))
+}
+
+func removeInstanceVolume(c *cli.Context, cfg *config.Config, identifier string) error {
+	volumePath := cfg.InstanceVolumePath(identifier)
+	if exists, err := utils.DirectoryExists(volumePath); err != nil || !exists {
+		if err != nil {
+			return err
+		}
+		return utils.SkippedError{Msg: "volume directory not exists"}
+	}
+	return utils.RemoveDirectory(volumePath, true)
+}
+
+func removeInstanceCompose(c *cli.Context, cfg *config.Config, identifier string) error {
+	composePath := cfg.InstanceComposePath(identifier)
+	if !utils.FileExists(composePath) {
+		return utils.SkippedError{Msg: "compose file not found"}
+	}
+	return utils.RemoveFile(composePath)
+}
+
+var instanceCfgMutex = new(sync.Mutex)
+
+func removeInstanceConfiguration(c *cli.Context, cfg *config.Config, identifier string) error {
+	instanceCfgMutex.Lock()
+	defer instanceCfgMutex.Unlock()
+
+	cfg.Instances.Remove(identifier)
+	if err := cfg.SaveAs(configPath); err != nil {
+		return err
+	}
+	return inventory.WriteCredentialsCsv(cfg)
+}
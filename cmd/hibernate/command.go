@@ -0,0 +1,308 @@
+package hibernate
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/utils"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/sync/errgroup"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+func Command() *cli.Command {
+	return &cli.Command{
+		Name:  "hibernate",
+		Usage: "Stop instances without HTTP traffic, they are woken up on next request (run it from cron)",
+		Flags: []cli.Flag{
+			&cli.DurationFlag{
+				Name:  "idle",
+				Usage: "Idle duration before hibernating, overrides hibernation.idle-timeout",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Only report idle instances, do not stop them",
+			},
+		},
+		Action: action,
+	}
+}
+
+const configPath = "multipress.yaml"
+
+// InstanceActivity is persisted between runs to detect traffic from network counters
+type InstanceActivity struct {
+	RxBytes      uint64    `json:"rx_bytes"`
+	LastActivity time.Time `json:"last_activity"`
+	Hibernated   bool      `json:"hibernated"`
+}
+
+type hibernationState struct {
+	lock       sync.Mutex
+	Activities map[string]*InstanceActivity
+	Idle       []string
+	// Unmeasured are instances whose container could not be inspected, left as they are
+	Unmeasured map[string]error
+}
+
+type Step struct {
+	Label string
+	Run   func(c *cli.Context, cfg *config.Config, state *hibernationState) error
+}
+
+var steps = []Step{
+	{"Initialize hibernation configuration", initializeHibernationConfiguration},
+	{"Deploy wake-up server", deployWaker},
+	{"Measure instances traffic", measureTraffic},
+	{"Hibernate idle instances", hibernateIdleInstances},
+	{"Save hibernation state", saveState},
+}
+
+func action(c *cli.Context) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	state, err := run(c, cfg)
+	if err != nil {
+		return err
+	}
+
+	t := table.NewWriter()
+	t.SetStyle(table.StyleLight)
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Instance", "Status", "Last activity"})
+	for _, identifier := range cfg.InstanceIdentifiers() {
+		if err, unmeasured := state.Unmeasured[identifier]; unmeasured {
+			t.AppendRow(table.Row{identifier, "unknown", err.Error()})
+			continue
+		}
+		activity, exists := state.Activities[identifier]
+		if !exists {
+			continue
+		}
+		status := "running"
+		if activity.Hibernated {
+			status = "hibernated"
+		}
+		t.AppendRow(table.Row{identifier, status, activity.LastActivity.Format(time.DateTime)})
+	}
+	t.Render()
+
+	return nil
+}
+
+// Run hibernates idle instances, returning them and instances whose traffic could not be measured
+func Run(c *cli.Context, cfg *config.Config) ([]string, map[string]error, error) {
+	state, err := run(c, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return state.Idle, state.Unmeasured, nil
+}
+
+func run(c *cli.Context, cfg *config.Config) (*hibernationState, error) {
+	state, err := loadState(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+
+	utils.PrintSeparator("Hibernation", '═')
+	for _, step := range steps {
+		if err := utils.Spin(utils.SpinOptions{Label: step.Label}, func() error {
+			return step.Run(c, cfg, state)
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// Hibernated returns instances stopped by hibernation, which are expected to be stopped
+func Hibernated(cfg *config.Config) (map[string]bool, error) {
+	state, err := loadState(cfg)
+	if err != nil {
+		return nil, err
+	}
+	hibernated := make(map[string]bool)
+	for identifier, activity := range state.Activities {
+		hibernated[identifier] = activity.Hibernated
+	}
+	return hibernated, nil
+}
+
+func initializeHibernationConfiguration(c *cli.Context, cfg *config.Config, state *hibernationState) error {
+	if cfg.Hibernation != nil {
+		return utils.SkippedError{Msg: "Configuration already defined"}
+	}
+	cfg.Hibernation = config.NewDefaultHibernationConfig()
+	return cfg.SaveAs(configPath)
+}
+
+//go:embed tmpl/waker.yaml.tmpl
+var wakerTmpl string
+
+type WakerTmplData struct {
+	Config     *config.Config
+	Executable string
+}
+
+// OnDemandTLS orders certificates of hibernated instances on their first request, the shared proxy cannot:
+// its on_demand_tls ask endpoint is a global option, which would answer for a single project
+func (d WakerTmplData) OnDemandTLS() bool {
+	return !d.Config.Caddy.Shared
+}
+
+func deployWaker(c *cli.Context, cfg *config.Config, state *hibernationState) error {
+	if cfg.Caddy.Shared && cfg.Caddy.IsAcme() && cfg.Caddy.DnsProvider == "" {
+		return errors.New("hibernation behind the shared proxy needs a wildcard certificate, set caddy.dns-provider or use the internal issuer")
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate multipress executable: %w", err)
+	}
+	if executable, err = filepath.EvalSymlinks(executable); err != nil {
+		return fmt.Errorf("failed to locate multipress executable: %w", err)
+	}
+
+	// Rendered again as labels depend on the proxy mode
+	previous, _ := os.ReadFile(cfg.WakerComposePath())
+	data := WakerTmplData{Config: cfg, Executable: executable}
+	if err := utils.ParseTemplateToFile(wakerTmpl, data, cfg.WakerComposePath()); err != nil {
+		return err
+	}
+	if current, err := os.ReadFile(cfg.WakerComposePath()); err == nil && bytes.Equal(previous, current) {
+		if running, _, err := utils.DockerContainerState(cfg.WakerContainerName()); err == nil && running {
+			return utils.SkippedError{Msg: "wake-up server already running"}
+		}
+	}
+
+	if _, err := utils.UpComposeFile(cfg.WakerComposePath()); err != nil {
+		return err
+	}
+	return nil
+}
+
+func measureTraffic(c *cli.Context, cfg *config.Config, state *hibernationState) error {
+	idleTimeout := c.Duration("idle")
+	if idleTimeout == 0 {
+		var err error
+		if idleTimeout, err = time.ParseDuration(cfg.Hibernation.IdleTimeout); err != nil {
+			return fmt.Errorf("invalid hibernation.idle-timeout: %w", err)
+		}
+	}
+
+	now := time.Now()
+	var g errgroup.Group
+	for _, identifier := range cfg.InstanceIdentifiers() {
+		g.Go(func() error {
+			running, rxBytes, err := containerTraffic(cfg.InstanceContainerName(identifier))
+
+			state.lock.Lock()
+			defer state.lock.Unlock()
+
+			// A missing container does not prevent other instances from hibernating
+			if err != nil {
+				state.Unmeasured[identifier] = err
+				return nil
+			}
+
+			activity, exists := state.Activities[identifier]
+			if !exists {
+				activity = &InstanceActivity{LastActivity: now}
+				state.Activities[identifier] = activity
+			}
+
+			// Counters are reset when the container restarts, any difference means traffic
+			if running && rxBytes != activity.RxBytes {
+				activity.LastActivity = now
+			}
+			activity.RxBytes = rxBytes
+			activity.Hibernated = !running
+
+			if running && now.Sub(activity.LastActivity) >= idleTimeout {
+				state.Idle = append(state.Idle, identifier)
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// containerTraffic returns whether the container is running and the bytes it received since started
+func containerTraffic(containerName string) (bool, uint64, error) {
+	running, _, err := utils.DockerContainerState(containerName)
+	if err != nil || !running {
+		return false, 0, err
+	}
+	rxBytes, err := utils.GetDockerContainerRxBytes(containerName)
+	return running, rxBytes, err
+}
+
+func hibernateIdleInstances(c *cli.Context, cfg *config.Config, state *hibernationState) error {
+	if len(state.Idle) == 0 {
+		return utils.SkippedError{Msg: "no idle instances"}
+	}
+	if c.Bool("dry-run") {
+		return utils.SkippedError{Msg: fmt.Sprintf("dry-run, %d idle instance(s)", len(state.Idle))}
+	}
+
+	var g errgroup.Group
+	errs := make([]error, len(state.Idle))
+	for i, identifier := range state.Idle {
+		g.Go(func() error {
+			if err := utils.StopDockerContainer(cfg.InstanceContainerName(identifier)); err != nil {
+				errs[i] = err
+				return nil
+			}
+			state.lock.Lock()
+			state.Activities[identifier].Hibernated = true
+			state.lock.Unlock()
+			return nil
+		})
+	}
+	_ = g.Wait()
+	return errors.Join(errs...)
+}
+
+func loadState(cfg *config.Config) (*hibernationState, error) {
+	state := &hibernationState{Activities: make(map[string]*InstanceActivity), Unmeasured: make(map[string]error)}
+	if !utils.FileExists(cfg.HibernationStatePath()) {
+		return state, nil
+	}
+
+	data, err := os.ReadFile(cfg.HibernationStatePath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hibernation state: %w", err)
+	}
+	if err := json.Unmarshal(data, &state.Activities); err != nil {
+		return nil, fmt.Errorf("failed to parse hibernation state: %w", err)
+	}
+	return state, nil
+}
+
+func saveState(c *cli.Context, cfg *config.Config, state *hibernationState) error {
+	// Forget removed instances
+	for identifier := range state.Activities {
+		if !cfg.HasInstance(identifier) {
+			delete(state.Activities, identifier)
+		}
+	}
+
+	data, err := json.MarshalIndent(state.Activities, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal hibernation state: %w", err)
+	}
+	return os.WriteFile(cfg.HibernationStatePath(), data, 0644)
+}
@@ -0,0 +1,211 @@
+package hosts
+
+import (
+	"cmp"
+	_ "embed"
+	"errors"
+	"fmt"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/hostsfile"
+	"github.com/quix-labs/multipress/utils"
+	"github.com/urfave/cli/v2"
+	"net"
+)
+
+func Command() *cli.Command {
+	return &cli.Command{
+		Name:  "hosts",
+		Usage: "Resolve model and instances hostnames locally, without public DNS",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "write",
+				Usage: "Write or update the managed block of the project in the hosts file",
+				Flags: []cli.Flag{
+					ipFlag(),
+					&cli.StringFlag{
+						Name:  "file",
+						Usage: "Hosts file to edit",
+						Value: hostsfile.DefaultPath,
+					},
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "Only print the block",
+					},
+				},
+				Action: writeAction,
+			},
+			{
+				Name:  "remove",
+				Usage: "Remove the managed block of the project from the hosts file",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "file",
+						Usage: "Hosts file to edit (default: the one written)",
+					},
+				},
+				Action: removeAction,
+			},
+			{
+				Name:  "dns",
+				Usage: "Deploy a DNS responder answering for the base domain and all its subdomains",
+				Flags: []cli.Flag{
+					ipFlag(),
+					&cli.StringFlag{
+						Name:  "listen",
+						Usage: "Host address and port published by the responder (default: configured or 127.0.0.1:53)",
+					},
+					&cli.BoolFlag{
+						Name:  "disable",
+						Usage: "Remove the responder",
+					},
+				},
+				Action: dnsAction,
+			},
+		},
+	}
+}
+
+func ipFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:  "ip",
+		Usage: "Address hostnames resolve to, where Caddy listens (default: configured or 127.0.0.1)",
+	}
+}
+
+const configPath = "multipress.yaml"
+
+// loadConfig initializes the hosts section, applying the ip flag
+func loadConfig(c *cli.Context) (*config.Config, error) {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Hosts == nil {
+		cfg.Hosts = config.NewDefaultHostsConfig()
+	}
+	if c.IsSet("ip") {
+		if net.ParseIP(c.String("ip")) == nil {
+			return nil, fmt.Errorf("invalid IP address %q", c.String("ip"))
+		}
+		cfg.Hosts.Ip = c.String("ip")
+	}
+	return cfg, nil
+}
+
+func writeAction(c *cli.Context) error {
+	cfg, err := loadConfig(c)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	if c.Bool("dry-run") {
+		fmt.Print(hostsfile.Block(cfg, cfg.Hosts.Ip))
+		return nil
+	}
+
+	if err := hostsfile.Write(cfg, c.String("file"), cfg.Hosts.Ip); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	// Remembered to keep the block in sync when instances change
+	cfg.Hosts.File = c.String("file")
+	if err := cfg.SaveAs(configPath); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	fmt.Printf("%d hostname(s) resolving to %s written to %s\n", len(hostsfile.Hostnames(cfg)), cfg.Hosts.Ip, cfg.Hosts.File)
+	return nil
+}
+
+func removeAction(c *cli.Context) error {
+	cfg, err := loadConfig(c)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	file := c.String("file")
+	if file == "" {
+		file = cmp.Or(cfg.Hosts.File, hostsfile.DefaultPath)
+	}
+
+	err = hostsfile.Remove(cfg.Project, file)
+	if errors.As(err, &utils.SkippedError{}) {
+		fmt.Printf("No block of %s in %s\n", cfg.Project, file)
+	} else if err != nil {
+		fmt.Println(err)
+		return err
+	} else {
+		fmt.Printf("Block of %s removed from %s\n", cfg.Project, file)
+	}
+
+	cfg.Hosts.File = ""
+	if err := cfg.SaveAs(configPath); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	return nil
+}
+
+func dnsAction(c *cli.Context) error {
+	cfg, err := loadConfig(c)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	cfg.Hosts.Dns = !c.Bool("disable")
+	if c.IsSet("listen") {
+		if _, _, err := net.SplitHostPort(c.String("listen")); err != nil {
+			fmt.Println(err)
+			return err
+		}
+		cfg.Hosts.DnsListen = c.String("listen")
+	}
+	if err := cfg.SaveAs(configPath); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	if err := utils.Spin(utils.SpinOptions{Label: "Deploying DNS responder"}, func() error {
+		return deployDns(cfg)
+	}); err != nil {
+		return err
+	}
+
+	if cfg.DnsEnabled() {
+		fmt.Printf("*.%s resolves to %s through %s, add it to your resolver, e.g. with systemd-resolved:\n", cfg.BaseDomain, cfg.Hosts.Ip, cfg.Hosts.DnsListen)
+		fmt.Printf("  /etc/systemd/resolved.conf.d/%s.conf: [Resolve] DNS=%s Domains=~%s\n", cfg.Project, cfg.Hosts.DnsListen, cfg.BaseDomain)
+	}
+	return nil
+}
+
+//go:embed tmpl/dns.yaml.tmpl
+var dnsTmpl string
+
+//go:embed tmpl/dns.Corefile.tmpl
+var corefileTmpl string
+
+func deployDns(cfg *config.Config) error {
+	if !cfg.DnsEnabled() {
+		// Remove the responder previously deployed
+		if !utils.FileExists(cfg.DnsComposePath()) {
+			return utils.SkippedError{Msg: "DNS responder disabled"}
+		}
+		if _, err := utils.DownComposeFile(cfg.DnsComposePath()); err != nil {
+			return err
+		}
+		return errors.Join(utils.RemoveFile(cfg.DnsComposePath()), utils.RemoveFile(cfg.DnsCorefilePath()))
+	}
+
+	if err := utils.ParseTemplateToFile(corefileTmpl, cfg, cfg.DnsCorefilePath()); err != nil {
+		return err
+	}
+	if err := utils.ParseTemplateToFile(dnsTmpl, cfg, cfg.DnsComposePath()); err != nil {
+		return err
+	}
+	_, err := utils.UpComposeFile(cfg.DnsComposePath())
+	return err
+}
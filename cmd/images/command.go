@@ -0,0 +1,149 @@
+package images
+
+import (
+	"fmt"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/utils"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/sync/errgroup"
+	"os"
+	"strings"
+)
+
+func Command() *cli.Command {
+	return &cli.Command{
+		Name:  "images",
+		Usage: "List images and digests currently running",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "record",
+				Usage: "Record running digests in configuration, so next deployments use the exact same images",
+			},
+		},
+		Action: action,
+	}
+}
+
+const configPath = "multipress.yaml"
+
+type imageStatus struct {
+	Running string
+	Digest  string
+	Err     error
+}
+
+func action(c *cli.Context) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	names := cfg.ImageNames()
+	statuses := make([]imageStatus, len(names))
+	var g errgroup.Group
+	for i, name := range names {
+		g.Go(func() error {
+			statuses[i] = inspect(cfg, name)
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	t := table.NewWriter()
+	t.SetStyle(table.StyleLight)
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Image", "Configured", "Running", "Digest", "Status"})
+	for i, name := range names {
+		image, s := cfg.ResolvedImage(name), statuses[i]
+		t.AppendRow(table.Row{name, image.Reference(), s.Running, s.Digest, formatStatus(image, s)})
+	}
+	t.Render()
+
+	if !c.Bool("record") {
+		return nil
+	}
+
+	if cfg.Images == nil {
+		cfg.Images = make(map[string]config.ImageConfig)
+	}
+	recorded := 0
+	for i, name := range names {
+		if statuses[i].Digest == "" {
+			continue
+		}
+		// Raw configuration, the wordpress tag is completed again on resolution
+		image := cfg.ImageConfig(name)
+		image.Digest = statuses[i].Digest
+		cfg.Images[name] = image
+		recorded++
+	}
+	if err := cfg.SaveAs(configPath); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	fmt.Printf("%d digest(s) recorded in %s\n", recorded, configPath)
+	return nil
+}
+
+// inspect returns the image running for name, base images being only known as pulled to build others
+func inspect(cfg *config.Config, name string) imageStatus {
+	image := cfg.ResolvedImage(name)
+
+	var s imageStatus
+	imageRef := image.Reference()
+	if !cfg.IsBaseImage(name) {
+		containerName, err := cfg.ServiceContainerName(name)
+		if err != nil {
+			return imageStatus{Err: err}
+		}
+		if s.Running, imageRef, err = utils.DockerContainerImage(containerName); err != nil {
+			return imageStatus{Err: fmt.Errorf("not running")}
+		}
+	}
+
+	repoDigests, err := utils.DockerImageRepoDigests(imageRef)
+	if err != nil {
+		return imageStatus{Running: s.Running, Err: fmt.Errorf("not pulled")}
+	}
+	if cfg.IsBaseImage(name) {
+		s.Running = image.Reference()
+	}
+	s.Digest = matchDigest(image.Image, repoDigests)
+	return s
+}
+
+// matchDigest returns the digest of repository among repoDigests, an image pushed to several registries has several
+func matchDigest(repository string, repoDigests []string) string {
+	for _, repoDigest := range repoDigests {
+		repo, digest, _ := strings.Cut(repoDigest, "@")
+		if repo == repository || strings.TrimPrefix(repo, "docker.io/library/") == repository || strings.TrimPrefix(repo, "docker.io/") == repository {
+			return digest
+		}
+	}
+	if len(repoDigests) > 0 {
+		_, digest, _ := strings.Cut(repoDigests[0], "@")
+		return digest
+	}
+	return ""
+}
+
+func formatStatus(image config.ImageConfig, s imageStatus) string {
+	running, _, _ := strings.Cut(s.Running, "@")
+	configured, _, _ := strings.Cut(image.Reference(), "@")
+	switch {
+	case s.Err != nil:
+		return text.FgRed.Sprint(s.Err.Error())
+	case running != configured:
+		return text.FgYellow.Sprint("REDEPLOY NEEDED")
+	case image.Digest != "" && image.Digest != s.Digest:
+		return text.FgYellow.Sprint("DIGEST CHANGED")
+	case image.Digest != "":
+		return text.FgGreen.Sprint("PINNED")
+	case s.Digest == "":
+		return text.FgYellow.Sprint("LOCAL BUILD")
+	}
+	return text.FgYellow.Sprint("TAG ONLY")
+}
@@ -0,0 +1,176 @@
+package instance
+
+import (
+	"errors"
+	"fmt"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/inventory"
+	"github.com/quix-labs/multipress/stack"
+	"github.com/quix-labs/multipress/utils"
+	"github.com/urfave/cli/v2"
+	"os"
+	"strings"
+	"time"
+)
+
+func Command() *cli.Command {
+	return &cli.Command{
+		Name:  "instance",
+		Usage: "Manage instances metadata",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "show",
+				Usage:     "Show an instance details",
+				ArgsUsage: "<identifier>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "format",
+						Aliases: []string{"f"},
+						Usage:   fmt.Sprintf("Output format (%s)", strings.Join(inventory.Formats, ", ")),
+						Value:   "table",
+					},
+					&cli.BoolFlag{
+						Name:  "with-secrets",
+						Usage: "Include passwords",
+					},
+				},
+				Action: showAction,
+			},
+			{
+				Name:      "set",
+				Usage:     "Edit owner, notes, tags or expiry of instances",
+				ArgsUsage: "[identifiers...]",
+				Flags: []cli.Flag{
+					stack.SelectFlag(),
+					&cli.StringFlag{
+						Name:  "owner",
+						Usage: "Name of the instances owner",
+					},
+					&cli.StringFlag{
+						Name:  "owner-email",
+						Usage: "Email of the instances owner",
+					},
+					&cli.StringFlag{
+						Name:  "notes",
+						Usage: "Free-form notes about the instances",
+					},
+					&cli.StringSliceFlag{
+						Name:  "tag",
+						Usage: "Add or replace a key=value tag",
+					},
+					&cli.StringSliceFlag{
+						Name:  "untag",
+						Usage: "Remove a tag by key",
+					},
+					&cli.StringFlag{
+						Name:  "expires",
+						Usage: "Expire instances after this duration from now (e.g. 14d), or 'never'",
+					},
+				},
+				Action: setAction,
+			},
+		},
+	}
+}
+
+const configPath = "multipress.yaml"
+
+func showAction(c *cli.Context) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	if c.Args().Len() != 1 {
+		fmt.Println("Usage: instance show <identifier>")
+		return errors.New("invalid argument")
+	}
+
+	identifier := c.Args().First()
+	if !cfg.HasInstance(identifier) {
+		err := fmt.Errorf("instance %s does not exist", identifier)
+		fmt.Println(err)
+		return err
+	}
+
+	records := inventory.Records(cfg, []string{identifier}, c.Bool("with-secrets"))
+	if c.String("format") == "table" {
+		inventory.WriteDetails(os.Stdout, records[0], c.Bool("with-secrets"))
+		return nil
+	}
+	if err := inventory.Write(os.Stdout, c.String("format"), records, c.Bool("with-secrets")); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	return nil
+}
+
+func setAction(c *cli.Context) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	if c.Args().Len() == 0 && !c.IsSet("select") {
+		fmt.Println("Usage: instance set [identifiers...] [--select tag=value] [options]")
+		return errors.New("no instance selected")
+	}
+
+	identifiers, err := stack.SelectedInstances(c, cfg, c.Args().Slice())
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	tags, err := config.ParseTags(c.StringSlice("tag"))
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	var expiresAt time.Time
+	if expires := c.String("expires"); expires != "" && expires != "never" {
+		duration, err := utils.ParseDuration(expires)
+		if err != nil {
+			fmt.Println(err)
+			return err
+		}
+		expiresAt = time.Now().Add(duration).Truncate(time.Second)
+	}
+
+	for _, identifier := range identifiers {
+		metadata := cfg.Instances.Metadata[identifier]
+		if c.IsSet("owner") {
+			metadata.Owner = c.String("owner")
+		}
+		if c.IsSet("owner-email") {
+			metadata.OwnerEmail = c.String("owner-email")
+		}
+		if c.IsSet("notes") {
+			metadata.Notes = c.String("notes")
+		}
+		if c.IsSet("expires") {
+			metadata.ExpiresAt = expiresAt
+		}
+		if len(tags) > 0 && metadata.Tags == nil {
+			metadata.Tags = make(map[string]string, len(tags))
+		}
+		for key, value := range tags {
+			metadata.Tags[key] = value
+		}
+		for _, key := range c.StringSlice("untag") {
+			delete(metadata.Tags, key)
+		}
+		cfg.Instances.SetMetadata(identifier, metadata)
+	}
+
+	if err := cfg.SaveAs(configPath); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	fmt.Printf("Updated %d instance(s)\n", len(identifiers))
+	return nil
+}
@@ -0,0 +1,68 @@
+package list
+
+import (
+	"fmt"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/inventory"
+	"github.com/quix-labs/multipress/stack"
+	"github.com/urfave/cli/v2"
+	"os"
+	"strings"
+)
+
+func Command() *cli.Command {
+	return &cli.Command{
+		Name:      "list",
+		Usage:     "List instances with their credentials",
+		ArgsUsage: "[identifiers...]",
+		Flags: []cli.Flag{
+			stack.SelectFlag(),
+			&cli.StringFlag{
+				Name:    "format",
+				Aliases: []string{"f"},
+				Usage:   fmt.Sprintf("Output format (%s)", strings.Join(inventory.Formats, ", ")),
+				Value:   "table",
+			},
+			&cli.BoolFlag{
+				Name:  "with-secrets",
+				Usage: "Include passwords",
+			},
+			&cli.BoolFlag{
+				Name:  "write-csv",
+				Usage: "Regenerate instance-credentials.csv from multipress.yaml",
+			},
+		},
+		Action: action,
+	}
+}
+
+func action(c *cli.Context) error {
+	configPath := "multipress.yaml"
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	if c.Bool("write-csv") {
+		if err := inventory.WriteCredentialsCsv(cfg); err != nil {
+			fmt.Println(err)
+			return err
+		}
+		fmt.Printf("Regenerated %s\n", cfg.CredentialsCsvPath())
+		return nil
+	}
+
+	identifiers, err := stack.SelectedInstances(c, cfg, c.Args().Slice())
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	records := inventory.Records(cfg, identifiers, c.Bool("with-secrets"))
+	if err := inventory.Write(os.Stdout, c.String("format"), records, c.Bool("with-secrets")); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package logs
+
+import (
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"github.com/jedib0t/go-pretty/v6/text"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/stack"
+	"github.com/quix-labs/multipress/utils"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/sync/errgroup"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+func Command() *cli.Command {
+	return &cli.Command{
+		Name:      "logs",
+		Usage:     "Stream logs of instances and services",
+		ArgsUsage: "[identifiers...]",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "all",
+				Usage: "Stream logs of all instances",
+			},
+			stack.SelectFlag(),
+			&cli.StringSliceFlag{
+				Name:    "service",
+				Aliases: []string{"s"},
+				Usage:   "Stream logs of services (caddy, dns, mysql, phpmyadmin, mail, redis, model, backups, waker)",
+			},
+			&cli.BoolFlag{
+				Name:    "follow",
+				Aliases: []string{"f"},
+				Usage:   "Follow log output",
+			},
+			&cli.StringFlag{
+				Name:  "since",
+				Usage: "Show logs since timestamp (e.g. 2024-01-02T13:23:37Z) or relative (e.g. 42m)",
+			},
+			&cli.StringFlag{
+				Name:  "tail",
+				Usage: "Number of lines to show from the end of the logs",
+				Value: "all",
+			},
+			&cli.BoolFlag{
+				Name:    "timestamps",
+				Aliases: []string{"t"},
+				Usage:   "Show timestamps",
+			},
+			&cli.BoolFlag{
+				Name:  "no-color",
+				Usage: "Produce monochrome output",
+			},
+		},
+		Action: action,
+	}
+}
+
+var prefixColors = []text.Colors{
+	{text.FgCyan}, {text.FgYellow}, {text.FgGreen}, {text.FgMagenta}, {text.FgBlue},
+	{text.FgHiCyan}, {text.FgHiYellow}, {text.FgHiGreen}, {text.FgHiMagenta}, {text.FgHiBlue},
+}
+
+type target struct {
+	Label         string
+	ContainerName string
+}
+
+func action(c *cli.Context) error {
+	configPath := "multipress.yaml"
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	targets, err := resolveTargets(c, cfg)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logsOptions := container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     c.Bool("follow"),
+		Since:      c.String("since"),
+		Tail:       c.String("tail"),
+		Timestamps: c.Bool("timestamps"),
+	}
+
+	// Align prefixes like docker compose does
+	width := 0
+	for _, t := range targets {
+		width = max(width, len(t.Label))
+	}
+
+	outputLock := new(sync.Mutex)
+	var g errgroup.Group
+	for i, t := range targets {
+		prefix := fmt.Sprintf("%-*s | ", width, t.Label)
+		if !c.Bool("no-color") {
+			prefix = prefixColors[i%len(prefixColors)].Sprint(prefix)
+		}
+		stdout := utils.NewPrefixWriter(os.Stdout, outputLock, prefix)
+		stderr := utils.NewPrefixWriter(os.Stderr, outputLock, prefix)
+
+		g.Go(func() error {
+			err := utils.StreamDockerLogs(ctx, t.ContainerName, logsOptions, stdout, stderr)
+			return errors.Join(err, stdout.Flush(), stderr.Flush())
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	return nil
+}
+
+func resolveTargets(c *cli.Context, cfg *config.Config) ([]target, error) {
+	var targets []target
+
+	for _, service := range c.StringSlice("service") {
+		containerName, err := cfg.ServiceContainerName(service)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target{Label: service, ContainerName: containerName})
+	}
+
+	identifiers := c.Args().Slice()
+	if c.Bool("all") && len(identifiers) > 0 {
+		return nil, errors.New("--all cannot be combined with identifiers")
+	}
+	if c.Bool("all") || c.IsSet("select") || len(identifiers) > 0 {
+		var err error
+		if identifiers, err = stack.SelectedInstances(c, cfg, identifiers); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, identifier := range identifiers {
+		targets = append(targets, target{Label: identifier, ContainerName: cfg.InstanceContainerName(identifier)})
+	}
+
+	if len(targets) == 0 {
+		return nil, errors.New("no target defined, use identifiers, --all or --service")
+	}
+	return targets, nil
+}
@@ -0,0 +1,51 @@
+package mail
+
+import (
+	"fmt"
+	"github.com/quix-labs/multipress/cmd/deploy"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/utils"
+	"github.com/urfave/cli/v2"
+)
+
+func Command() *cli.Command {
+	return &cli.Command{
+		Name:   "mail",
+		Usage:  "Apply the mail configuration (catcher or relay) to the model and all instances",
+		Args:   false,
+		Action: action,
+	}
+}
+
+const configPath = "multipress.yaml"
+
+func action(c *cli.Context) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	utils.PrintSeparator("Mail", '═')
+	for _, step := range deploy.MailSteps {
+		if err := utils.Spin(utils.SpinOptions{Label: step.Label}, func() error {
+			return step.Run(c, cfg)
+		}); err != nil {
+			return err
+		}
+	}
+
+	switch cfg.Mail.Mode {
+	case config.MailModeCatcher:
+		if cfg.Mail.UiEnabled() {
+			fmt.Printf("Emails are captured, browse them at %s (%s / %s)\n", cfg.MailUrl(), cfg.Mail.Access.Username, cfg.Mail.Access.Password)
+		} else {
+			fmt.Println("Emails are captured, the web interface is not published")
+		}
+	case config.MailModeRelay:
+		fmt.Printf("Emails are relayed through %s:%d\n", cfg.Mail.Relay.Host, cfg.Mail.Relay.Port)
+	default:
+		fmt.Println("Emails are disabled")
+	}
+	return nil
+}
@@ -1,10 +1,10 @@
 package new
 
 import (
-	_ "embed"
 	"fmt"
 	"github.com/gosimple/slug"
 	"github.com/manifoldco/promptui"
+	"github.com/quix-labs/multipress/cmd/deploy"
 	"github.com/quix-labs/multipress/config"
 	"github.com/quix-labs/multipress/utils"
 	"github.com/urfave/cli/v2"
@@ -128,16 +128,6 @@ func askConfig(c *cli.Context) (*config.Config, error) {
 	return cfg, nil
 }
 
-//go:embed tmpl/wordpress.Dockerfile
-var wordpressTmpl string
-
 func cloneWpDockerfile(cfg *config.Config, projectPath string) error {
-	if err := utils.ParseTemplateToFile(
-		wordpressTmpl,
-		cfg,
-		filepath.Join(projectPath, "wordpress.Dockerfile"),
-	); err != nil {
-		return err
-	}
-	return nil
+	return deploy.WriteWordpressDockerfile(cfg, filepath.Join(projectPath, cfg.WordpressDockerfilePath()))
 }
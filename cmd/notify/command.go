@@ -0,0 +1,255 @@
+package notify
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/stack"
+	"github.com/quix-labs/multipress/utils"
+	"github.com/urfave/cli/v2"
+	htmltemplate "html/template"
+	"net/mail"
+	"os"
+	"path/filepath"
+	texttemplate "text/template"
+	"time"
+)
+
+func Command() *cli.Command {
+	return &cli.Command{
+		Name:      "notify",
+		Usage:     "Email credentials to instances owners",
+		ArgsUsage: "[identifiers...]",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "all",
+				Usage: "Notify owners of all instances",
+			},
+			stack.SelectFlag(),
+			&cli.StringFlag{
+				Name:  "to",
+				Usage: "Send all emails to this address instead of owners emails",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Write .eml files instead of sending",
+			},
+			&cli.StringFlag{
+				Name:  "output-dir",
+				Usage: "Directory of .eml files written by --dry-run",
+				Value: "emails",
+			},
+		},
+		Action: action,
+	}
+}
+
+const configPath = "multipress.yaml"
+
+//go:embed tmpl/credentials.txt.tmpl
+var defaultTextTmpl string
+
+//go:embed tmpl/credentials.html.tmpl
+var defaultHtmlTmpl string
+
+type MessageData struct {
+	Identifier string
+	Project    string
+	URL        string
+	AdminURL   string
+	Username   string
+	Password   string
+	Email      string
+	Owner      string
+	OwnerEmail string
+	ExpiresAt  time.Time
+}
+
+type templates struct {
+	Subject *texttemplate.Template
+	Text    *texttemplate.Template
+	Html    *htmltemplate.Template
+}
+
+type notification struct {
+	Identifier string
+	To         string
+	Err        error
+}
+
+func action(c *cli.Context) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	if c.Args().Len() == 0 && !c.Bool("all") && !c.IsSet("select") {
+		fmt.Println("Usage: notify [identifiers...|--all|--select tag=value]")
+		return errors.New("no instance selected")
+	}
+
+	identifiers, err := stack.SelectedInstances(c, cfg, c.Args().Slice())
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	// A dry-run only writes .eml files, defaults are used without being saved
+	if cfg.Notify == nil {
+		cfg.Notify = config.NewDefaultNotifyConfig(cfg)
+		if c.Bool("dry-run") {
+			fmt.Printf("Default notify configuration used, not saved in %s by --dry-run\n", configPath)
+		} else {
+			if err := cfg.SaveAs(configPath); err != nil {
+				fmt.Println(err)
+				return err
+			}
+			fmt.Printf("Notify configuration initialized with SMTP %s:%d, edit it in %s\n", cfg.Notify.Smtp.Host, cfg.Notify.Smtp.Port, configPath)
+		}
+	}
+
+	tmpl, err := loadTemplates(cfg.Notify)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	if c.Bool("dry-run") {
+		if err := utils.CreateDirectoryIfNotExists(c.String("output-dir")); err != nil {
+			fmt.Println(err)
+			return err
+		}
+	}
+
+	notifications := make([]notification, len(identifiers))
+	label := fmt.Sprintf("Sending %d email(s) via %s:%d", len(identifiers), cfg.Notify.Smtp.Host, cfg.Notify.Smtp.Port)
+	if c.Bool("dry-run") {
+		label = fmt.Sprintf("Writing %d email(s) into %s", len(identifiers), c.String("output-dir"))
+	}
+	_ = utils.Spin(utils.SpinOptions{Label: label}, func() error {
+		failed := 0
+		for i, identifier := range identifiers {
+			notifications[i] = notify(c, cfg, tmpl, identifier)
+			if notifications[i].Err != nil {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d/%d failed", failed, len(identifiers))
+		}
+		return nil
+	})
+
+	t := table.NewWriter()
+	t.SetStyle(table.StyleLight)
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Instance", "Recipient", "Status", "Error"})
+	failed := 0
+	for _, n := range notifications {
+		status, details := text.FgGreen.Sprint("SENT"), ""
+		if c.Bool("dry-run") {
+			status = text.FgGreen.Sprint("WRITTEN")
+		}
+		if n.Err != nil {
+			failed++
+			status, details = text.FgRed.Sprint("FAIL"), n.Err.Error()
+		}
+		t.AppendRow(table.Row{n.Identifier, n.To, status, details})
+	}
+	t.Render()
+
+	if failed > 0 {
+		return fmt.Errorf("%d notification(s) failed", failed)
+	}
+	return nil
+}
+
+func notify(c *cli.Context, cfg *config.Config, tmpl *templates, identifier string) notification {
+	credentials := cfg.Instances.Credentials[identifier]
+	metadata := cfg.Instances.Metadata[identifier]
+
+	n := notification{Identifier: identifier, To: c.String("to")}
+	if n.To == "" && metadata.OwnerEmail != "" {
+		n.To = (&mail.Address{Name: metadata.Owner, Address: metadata.OwnerEmail}).String()
+	}
+	if n.To == "" {
+		n.Err = errors.New("owner email not defined, set it with 'multipress instance set --owner-email'")
+		return n
+	}
+
+	data := MessageData{
+		Identifier: identifier,
+		Project:    cfg.Project,
+		URL:        cfg.InstanceUrl(identifier),
+		AdminURL:   cfg.InstanceUrl(identifier) + "/wp-admin/",
+		Username:   credentials.Username,
+		Password:   credentials.Password,
+		Email:      credentials.Email,
+		Owner:      metadata.Owner,
+		OwnerEmail: metadata.OwnerEmail,
+		ExpiresAt:  metadata.ExpiresAt,
+	}
+
+	m := message{From: cfg.Notify.Smtp.From, To: n.To}
+	var subject, textBody, htmlBody bytes.Buffer
+	if n.Err = tmpl.Subject.Execute(&subject, data); n.Err != nil {
+		return n
+	}
+	if n.Err = tmpl.Text.Execute(&textBody, data); n.Err != nil {
+		return n
+	}
+	if n.Err = tmpl.Html.Execute(&htmlBody, data); n.Err != nil {
+		return n
+	}
+	m.Subject, m.Text, m.Html = subject.String(), textBody.String(), htmlBody.String()
+
+	content, err := m.Bytes(cfg.BaseDomain)
+	if err != nil {
+		n.Err = err
+		return n
+	}
+
+	if c.Bool("dry-run") {
+		// Contains the password, keep it private
+		n.Err = os.WriteFile(filepath.Join(c.String("output-dir"), identifier+".eml"), content, 0600)
+		return n
+	}
+	n.Err = send(cfg.Notify.Smtp, m, content)
+	return n
+}
+
+func loadTemplates(notifyCfg *config.NotifyConfig) (*templates, error) {
+	textTmpl, htmlTmpl := defaultTextTmpl, defaultHtmlTmpl
+	if notifyCfg.TextTemplate != "" {
+		data, err := os.ReadFile(notifyCfg.TextTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read text template: %w", err)
+		}
+		textTmpl = string(data)
+	}
+	if notifyCfg.HtmlTemplate != "" {
+		data, err := os.ReadFile(notifyCfg.HtmlTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read html template: %w", err)
+		}
+		htmlTmpl = string(data)
+	}
+
+	var err error
+	tmpl := &templates{}
+	if tmpl.Subject, err = texttemplate.New("subject").Parse(notifyCfg.Subject); err != nil {
+		return nil, fmt.Errorf("invalid subject template: %w", err)
+	}
+	if tmpl.Text, err = texttemplate.New("text").Parse(textTmpl); err != nil {
+		return nil, fmt.Errorf("invalid text template: %w", err)
+	}
+	if tmpl.Html, err = htmltemplate.New("html").Parse(htmlTmpl); err != nil {
+		return nil, fmt.Errorf("invalid html template: %w", err)
+	}
+	return tmpl, nil
+}
@@ -1,14 +1,14 @@
 package replicate
 
 import (
-	"database/sql"
 	_ "embed"
-	"encoding/csv"
 	"errors"
 	"fmt"
 	"github.com/docker/docker/api/types/container"
-	"github.com/go-sql-driver/mysql"
 	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/database"
+	"github.com/quix-labs/multipress/hostsfile"
+	"github.com/quix-labs/multipress/inventory"
 	"github.com/quix-labs/multipress/utils"
 	"github.com/urfave/cli/v2"
 	"golang.org/x/sync/errgroup"
@@ -16,6 +16,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"sync"
+	"time"
 )
 
 func Command() *cli.Command {
@@ -24,12 +25,33 @@ func Command() *cli.Command {
 		Usage:     "Replicate model onto multiple instances",
 		Action:    action,
 		ArgsUsage: "<count>",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "expires",
+				Usage: "Expire instances after this duration (e.g. 14d, 2w, 36h), see 'multipress expire'",
+			},
+			&cli.StringSliceFlag{
+				Name:  "tag",
+				Usage: "Tag instances with key=value, usable with --select",
+			},
+			&cli.StringFlag{
+				Name:  "owner",
+				Usage: "Name of the instances owner",
+			},
+			&cli.StringFlag{
+				Name:  "owner-email",
+				Usage: "Email of the instances owner",
+			},
+			&cli.StringFlag{
+				Name:  "notes",
+				Usage: "Free-form notes about the instances",
+			},
+		},
 	}
 }
 
 const dumpPath = "model_dump.sql"
 const configPath = "multipress.yaml"
-const credentialCsvPath = "instance-credentials.csv"
 
 type Step struct {
 	Label string
@@ -44,7 +66,6 @@ type InstanceStep struct {
 var preSteps = []Step{
 	{"Initialize instances configuration", initializeInstancesConfiguration},
 	{"Dump model database", dumpModelDatabase},
-	{"Create credentials.csv", createCsvCredentials},
 }
 
 var steps = []InstanceStep{
@@ -57,6 +78,7 @@ var steps = []InstanceStep{
 
 var postSteps = []Step{
 	{"Delete model dump", deleteModelDump},
+	{"Updating hosts file", updateHostsFile},
 }
 
 func action(c *cli.Context) error {
@@ -82,6 +104,17 @@ func action(c *cli.Context) error {
 		return err
 	}
 
+	if c.String("expires") != "" {
+		if _, err := utils.ParseDuration(c.String("expires")); err != nil {
+			fmt.Println(err)
+			return err
+		}
+	}
+	if _, err := config.ParseTags(c.StringSlice("tag")); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
 	utils.PrintSeparator("Pre-Steps", '═')
 	for _, step := range preSteps {
 		if err := utils.Spin(utils.SpinOptions{Label: step.Label}, func() error {
@@ -130,6 +163,9 @@ func action(c *cli.Context) error {
 	for _, identifier := range identifiers {
 		fmt.Printf("URL: %s - Username: %s - Password: %s\n", cfg.InstanceUrl(identifier), cfg.Instances.Credentials[identifier].Username, cfg.Instances.Credentials[identifier].Password)
 	}
+	if len(identifiers) > 0 && !cfg.Instances.Metadata[identifiers[0]].ExpiresAt.IsZero() {
+		fmt.Printf("Expires at: %s\n", cfg.Instances.Metadata[identifiers[0]].ExpiresAt.Format(time.DateTime))
+	}
 	return nil
 }
 
@@ -143,12 +179,17 @@ func initializeInstancesConfiguration(c *cli.Context, cfg *config.Config) error
 }
 
 func dumpModelDatabase(c *cli.Context, cfg *config.Config) error {
+	dialect, err := database.DialectOf(cfg)
+	if err != nil {
+		return err
+	}
+
 	output, err := utils.ExecDockerCmd(cfg.MysqlContainerName(), container.ExecOptions{
 		Env: []string{fmt.Sprintf(`MYSQL_PWD=%s`, cfg.MySql.RootPassword)},
-		Cmd: []string{"mysqldump", "-u", "root", "model"},
+		Cmd: dialect.DumpCommand("root", "model"),
 	}, nil)
 	if err != nil {
-		return fmt.Errorf("failed to execute mysqldump: %w", err)
+		return fmt.Errorf("failed to dump model database: %w", err)
 	}
 
 	if err := os.WriteFile(dumpPath, []byte(output), 0644); err != nil {
@@ -157,23 +198,6 @@ func dumpModelDatabase(c *cli.Context, cfg *config.Config) error {
 	return nil
 }
 
-func createCsvCredentials(c *cli.Context, cfg *config.Config) error {
-	if utils.FileExists(credentialCsvPath) {
-		return utils.SkippedError{Msg: "csv already exists"}
-	}
-
-	file, err := os.Create(credentialCsvPath)
-	if err != nil {
-		return fmt.Errorf("failed to create CSV file: %w", err)
-	}
-	if err := file.Close(); err != nil {
-		return fmt.Errorf("failed to close CSV file: %w", err)
-	}
-
-	return appendCsvCredential([]string{"URL", "Username", "Password", "DB Username", "DB Password", "DB Database"})
-
-}
-
 var instanceCfgMutex = new(sync.Mutex)
 
 func configureInstance(c *cli.Context, cfg *config.Config, identifier string) error {
@@ -181,19 +205,37 @@ func configureInstance(c *cli.Context, cfg *config.Config, identifier string) er
 	defer instanceCfgMutex.Unlock()
 
 	credentials := config.NewDefaultInstanceCredentialConfig(cfg, identifier)
+	redisDatabase, err := cfg.Instances.NextRedisDatabase()
+	if err != nil {
+		return err
+	}
+	credentials.RedisDatabase = redisDatabase
 	cfg.Instances.Credentials[identifier] = *credentials
 
+	tags, err := config.ParseTags(c.StringSlice("tag"))
+	if err != nil {
+		return err
+	}
+	metadata := config.InstanceMetadata{
+		Owner:      c.String("owner"),
+		OwnerEmail: c.String("owner-email"),
+		Notes:      c.String("notes"),
+		Tags:       tags,
+		CreatedAt:  time.Now().Truncate(time.Second),
+	}
+	if expires := c.String("expires"); expires != "" {
+		duration, err := utils.ParseDuration(expires)
+		if err != nil {
+			return err
+		}
+		metadata.ExpiresAt = metadata.CreatedAt.Add(duration)
+	}
+	cfg.Instances.SetMetadata(identifier, metadata)
+
 	if err := cfg.SaveAs(configPath); err != nil {
 		return err
 	}
-	return appendCsvCredential([]string{
-		cfg.InstanceUrl(identifier),
-		credentials.Username,
-		credentials.Password,
-		credentials.DBUser,
-		credentials.DBPassword,
-		credentials.DBName,
-	})
+	return inventory.WriteCredentialsCsv(cfg)
 }
 
 func cloneModelVolumeInstance(c *cli.Context, cfg *config.Config, identifier string) error {
@@ -224,41 +266,27 @@ func bootstrapInstanceDatabase(c *cli.Context, cfg *config.Config, identifier st
 		return errors.New("instance credentials does not exist")
 	}
 
-	mysqlIP, err := utils.GetDockerContainerIP(cfg.MysqlContainerName())
+	dialect, err := database.DialectOf(cfg)
 	if err != nil {
 		return err
 	}
 
-	mysqlConnector, err := mysql.NewConnector(&mysql.Config{User: "root", Passwd: cfg.MySql.RootPassword, Addr: mysqlIP})
+	db, err := database.Connect(cfg, "")
 	if err != nil {
-		return fmt.Errorf("failed to connect to MySQL: %w", err)
+		return err
 	}
-	db := sql.OpenDB(mysqlConnector)
 	defer db.Close()
 
 	// Create user + database
-	statements := []string{
-		fmt.Sprintf("DROP DATABASE IF EXISTS `%s`", credentials.DBName),
-		fmt.Sprintf("DROP USER IF EXISTS '%s'@'%%'", credentials.DBUser),
-		fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s`", credentials.DBName),
-		fmt.Sprintf("CREATE USER IF NOT EXISTS '%s'@'%%' IDENTIFIED BY '%s'", credentials.DBUser, credentials.DBPassword),
-		fmt.Sprintf("GRANT ALL PRIVILEGES ON `%s`.* TO '%s'@'%%'", credentials.DBName, credentials.DBUser),
-		"FLUSH PRIVILEGES",
-	}
-
-	for _, statement := range statements {
-		//fmt.Printf("Executing SQL: %s\n", statement)
-		if _, err := db.Exec(statement); err != nil {
-			return fmt.Errorf("failed to execute statement %q: %w", statement, err)
-		}
+	if err := database.ExecStatements(db, dialect.CreateStatements(credentials)); err != nil {
+		return err
 	}
 
 	// Create instance connection
-	mysqlInstanceConnector, err := mysql.NewConnector(&mysql.Config{User: "root", Passwd: cfg.MySql.RootPassword, Addr: mysqlIP, DBName: credentials.DBName})
+	dbInstance, err := database.Connect(cfg, credentials.DBName)
 	if err != nil {
 		return fmt.Errorf("failed to connect to instance database: %w", err)
 	}
-	dbInstance := sql.OpenDB(mysqlInstanceConnector)
 	defer dbInstance.Close()
 
 	// Import dump
@@ -269,27 +297,21 @@ func bootstrapInstanceDatabase(c *cli.Context, cfg *config.Config, identifier st
 
 	if _, err = utils.ExecDockerCmd(cfg.MysqlContainerName(), container.ExecOptions{
 		Env: []string{fmt.Sprintf(`MYSQL_PWD=%s`, cfg.MySql.RootPassword)},
-		Cmd: []string{"mysql", "-u", "root", credentials.DBName},
+		Cmd: dialect.ClientCommand("root", credentials.DBName),
 	}, dumpData); err != nil {
 		return err
 	}
 
 	// Replace database entries
 	wordpressHost := cfg.InstanceUrl(identifier)
-	statements = []string{
+	statements := []string{
 		fmt.Sprintf("UPDATE wp_users SET user_pass = MD5('%s'), user_url='%s', user_login='%s', user_nicename='%s', display_name='%s', user_email='%s' WHERE wp_users.ID = 1;",
 			credentials.Password, wordpressHost, credentials.Username, credentials.Username, credentials.Username, credentials.Email),
 		fmt.Sprintf("UPDATE wp_options SET option_value = '%s' WHERE wp_options.option_name = 'siteurl' OR wp_options.option_name = 'home';", wordpressHost),
 		fmt.Sprintf("UPDATE wp_options SET option_value = '%s' WHERE wp_options.option_name = 'admin_email';", credentials.Email),
 	}
 
-	for _, statement := range statements {
-		//fmt.Printf("Executing SQL: %s\n", statement)
-		if _, err := dbInstance.Exec(statement); err != nil {
-			return fmt.Errorf("failed to execute statement %q: %w", statement, err)
-		}
-	}
-	return nil
+	return database.ExecStatements(dbInstance, statements)
 }
 
 //go:embed tmpl/instance.yaml.tmpl
@@ -301,19 +323,21 @@ type InstanceTmplData struct {
 	Credentials config.CredentialsConfig
 }
 
-func deployInstance(c *cli.Context, cfg *config.Config, identifier string) error {
+// WriteInstanceCompose renders the instance compose file from the configuration
+func WriteInstanceCompose(cfg *config.Config, identifier string) error {
 	data := InstanceTmplData{
 		Identifier:  identifier,
 		Config:      cfg,
 		Credentials: cfg.Instances.Credentials[identifier],
 	}
+	return utils.ParseTemplateToFile(instanceTmpl, data, cfg.InstanceComposePath(identifier))
+}
 
-	composeFilename := fmt.Sprintf("compose.%s.yaml", identifier)
-
-	if err := utils.ParseTemplateToFile(instanceTmpl, data, composeFilename); err != nil {
+func deployInstance(c *cli.Context, cfg *config.Config, identifier string) error {
+	if err := WriteInstanceCompose(cfg, identifier); err != nil {
 		return err
 	}
-	if _, err := utils.UpComposeFile(composeFilename); err != nil {
+	if _, err := utils.UpComposeFile(cfg.InstanceComposePath(identifier)); err != nil {
 		return err
 	}
 
@@ -341,24 +365,6 @@ func deleteModelDump(c *cli.Context, cfg *config.Config) error {
 	return utils.RemoveFile(dumpPath)
 }
 
-var csvLock = new(sync.Mutex)
-
-func appendCsvCredential(data []string) error {
-	csvLock.Lock()
-	defer csvLock.Unlock()
-
-	file, err := os.OpenFile(credentialCsvPath, os.O_APPEND|os.O_WRONLY, os.ModeAppend)
-	if err != nil {
-		return fmt.Errorf("failed to open CSV file: %w", err)
-	}
-	defer file.Close()
-
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
-
-	if err := writer.Write(data); err != nil {
-		return fmt.Errorf("failed to write data to CSV file: %w", err)
-	}
-
-	return nil
+func updateHostsFile(c *cli.Context, cfg *config.Config) error {
+	return hostsfile.Sync(cfg)
 }
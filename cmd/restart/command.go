@@ -0,0 +1,43 @@
+package restart
+
+import (
+	"fmt"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/stack"
+	"github.com/quix-labs/multipress/utils"
+	"github.com/urfave/cli/v2"
+)
+
+func Command() *cli.Command {
+	return &cli.Command{
+		Name:      "restart",
+		Usage:     "Restart project resources, all of them by default",
+		ArgsUsage: "[identifiers...]",
+		Flags:     stack.Flags(),
+		Action:    action,
+	}
+}
+
+func action(c *cli.Context) error {
+	configPath := "multipress.yaml"
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	selection, err := stack.ParseSelection(c, cfg)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	// Stop in reverse order, then start in order so dependencies are healthy first
+	steps := append(stack.DownSteps(cfg, selection), stack.UpSteps(cfg, selection)...)
+	for _, step := range steps {
+		if err := utils.Spin(utils.SpinOptions{Label: step.Label}, step.Run); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,381 @@
+package restore
+
+import (
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/quix-labs/multipress/backupstore"
+	"github.com/quix-labs/multipress/cmd/backup"
+	"github.com/quix-labs/multipress/cmd/replicate"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/database"
+	"github.com/quix-labs/multipress/hostsfile"
+	"github.com/quix-labs/multipress/inventory"
+	"github.com/quix-labs/multipress/utils"
+	"github.com/urfave/cli/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func Command() *cli.Command {
+	return &cli.Command{
+		Name:      "restore",
+		Usage:     "Restore an instance from a backup, recreating it when destroyed",
+		ArgsUsage: "<identifier>",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "date",
+				Usage: "Backup to restore, as named in the backups directory (e.g. 20260103_030000), the most recent when empty",
+			},
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "Extract the backup (sources, dump.sql, compose.yaml) into this directory instead of restoring the instance",
+			},
+			&cli.BoolFlag{
+				Name:  "list",
+				Usage: "List backups of the instance",
+			},
+			backup.IdentityFlag(),
+		},
+		Action: action,
+	}
+}
+
+const configPath = "multipress.yaml"
+
+type Step struct {
+	Label string
+	Run   func(c *cli.Context, cfg *config.Config, store *backupstore.Store, manifest *backupstore.Manifest) error
+}
+
+// steps prepare the volume and database next to the live ones, which are replaced only once both are complete
+var steps = []Step{
+	{"Restoring configuration", restoreConfiguration},
+	{"Extracting volume", extractVolume},
+	{"Importing database", importDatabase},
+	{"Stopping containers", stopInstance},
+	{"Replacing volume and database", replaceInstance},
+	{"Writing compose file", writeCompose},
+	{"Starting containers", startInstance},
+	{"Flushing object cache", flushObjectCache},
+	{"Updating hosts file", updateHostsFile},
+}
+
+func action(c *cli.Context) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	if c.NArg() != 1 {
+		err := errors.New("expected one instance identifier")
+		fmt.Println(err)
+		return err
+	}
+	identifier := c.Args().First()
+
+	if c.Bool("list") {
+		return listBackups(cfg, identifier)
+	}
+
+	date, manifest, err := loadManifest(cfg, identifier, c.String("date"))
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	// Encrypted backups are decrypted transparently
+	store, err := backup.Store(cfg, c.String("identity"))
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	if err := store.Unseal(manifest); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	if output := c.String("output"); output != "" {
+		if err := utils.Spin(utils.SpinOptions{Label: "Extracting backup"}, func() error {
+			return store.Extract(manifest.Entries, "", output)
+		}); err != nil {
+			return err
+		}
+		fmt.Printf("Backup %s of %s extracted to %s\n", date, identifier, output)
+		return nil
+	}
+
+	utils.PrintSeparator(fmt.Sprintf("Restore %s from %s", identifier, date), '═')
+	for _, step := range steps {
+		if err := utils.Spin(utils.SpinOptions{Label: step.Label}, func() error {
+			return step.Run(c, cfg, store, manifest)
+		}); err != nil {
+			if discardErr := discardRestore(cfg, manifest); discardErr != nil {
+				fmt.Println(discardErr)
+			}
+			return err
+		}
+	}
+
+	credentials := cfg.Instances.Credentials[identifier]
+	utils.PrintSeparator("Access", '═')
+	fmt.Printf("URL: %s - Username: %s - Password: %s\n", cfg.InstanceUrl(identifier), credentials.Username, credentials.Password)
+	return nil
+}
+
+func loadManifest(cfg *config.Config, identifier string, date string) (string, *backupstore.Manifest, error) {
+	if date == "" {
+		return backup.LatestManifest(cfg, identifier)
+	}
+	if !utils.FileExists(backup.ManifestPath(cfg, date, identifier)) {
+		return "", nil, fmt.Errorf("no backup of %s on %s", identifier, date)
+	}
+	manifest, err := backup.LoadManifest(cfg, date, identifier)
+	return date, manifest, err
+}
+
+func listBackups(cfg *config.Config, identifier string) error {
+	dates, err := backup.Dates(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	t := table.NewWriter()
+	t.SetStyle(table.StyleLight)
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Date", "Backed up at", "Size", "Stored", "Encrypted"})
+	for _, date := range dates {
+		if !utils.FileExists(backup.ManifestPath(cfg, date, identifier)) {
+			continue
+		}
+		manifest, err := backup.LoadManifest(cfg, date, identifier)
+		if err != nil {
+			fmt.Println(err)
+			return err
+		}
+		encrypted := "no"
+		if manifest.Sealed != nil {
+			encrypted = "yes"
+		}
+		t.AppendRow(table.Row{date, manifest.Date.Format(time.DateTime), utils.FormatBytes(manifest.Size), utils.FormatBytes(manifest.Stored), encrypted})
+	}
+	t.Render()
+	return nil
+}
+
+func stopInstance(c *cli.Context, cfg *config.Config, store *backupstore.Store, manifest *backupstore.Manifest) error {
+	composePath := cfg.InstanceComposePath(manifest.Identifier)
+	if !utils.FileExists(composePath) {
+		return utils.SkippedError{Msg: "compose file not found"}
+	}
+	_, err := utils.DownComposeFile(composePath)
+	return err
+}
+
+// restoreConfiguration recreates destroyed instances, existing ones keeping their current credentials
+func restoreConfiguration(c *cli.Context, cfg *config.Config, store *backupstore.Store, manifest *backupstore.Manifest) error {
+	if cfg.Instances == nil {
+		cfg.Instances = config.NewDefaultInstancesConfig(cfg)
+	}
+	if _, exists := cfg.Instances.Credentials[manifest.Identifier]; exists {
+		return utils.SkippedError{Msg: "instance already configured"}
+	}
+
+	cfg.Instances.Credentials[manifest.Identifier] = manifest.Credentials
+	cfg.Instances.SetMetadata(manifest.Identifier, manifest.Metadata)
+	if err := cfg.SaveAs(configPath); err != nil {
+		return err
+	}
+	return inventory.WriteCredentialsCsv(cfg)
+}
+
+// restorePaths returns where sources are extracted and where the live volume is kept until replaced
+func restorePaths(cfg *config.Config, identifier string) (string, string) {
+	volumePath := cfg.InstanceVolumePath(identifier)
+	return volumePath + ".restore", volumePath + ".previous"
+}
+
+// restoreDatabases returns the databases receiving the dump and the live tables until replaced
+func restoreDatabases(credentials config.CredentialsConfig) (string, string) {
+	return credentials.DBName + "_restore", credentials.DBName + "_previous"
+}
+
+// extractVolume extracts sources next to the volume, left untouched
+func extractVolume(c *cli.Context, cfg *config.Config, store *backupstore.Store, manifest *backupstore.Manifest) error {
+	restorePath, _ := restorePaths(cfg, manifest.Identifier)
+	if err := utils.RemoveDirectory(restorePath, true); err != nil {
+		return err
+	}
+
+	if err := store.Extract(manifest.Entries, "sources", restorePath); err != nil {
+		return err
+	}
+	if err := filepath.WalkDir(restorePath, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, cfg.Uid, cfg.Gid)
+	}); err != nil {
+		return fmt.Errorf("failed to change ownership of the volume: %w", err)
+	}
+	return nil
+}
+
+// importDatabase imports the dump into a scratch database, the live one being untouched if it fails
+func importDatabase(c *cli.Context, cfg *config.Config, store *backupstore.Store, manifest *backupstore.Manifest) error {
+	dumpData, err := store.ReadFile(manifest.Entries, "dump.sql")
+	if err != nil {
+		return err
+	}
+
+	dialect, err := database.DialectOf(cfg)
+	if err != nil {
+		return err
+	}
+
+	db, err := database.Connect(cfg, "")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	restoreDB, _ := restoreDatabases(cfg.Instances.Credentials[manifest.Identifier])
+	if err := database.ExecStatements(db, dialect.ScratchStatements(restoreDB)); err != nil {
+		return err
+	}
+
+	output, err := utils.ExecDockerCmd(cfg.MysqlContainerName(), container.ExecOptions{
+		Env: []string{fmt.Sprintf(`MYSQL_PWD=%s`, cfg.MySql.RootPassword)},
+		Cmd: dialect.ClientCommand("root", restoreDB),
+	}, dumpData)
+	if err != nil {
+		return fmt.Errorf("failed to import dump: %w: %s", err, strings.TrimSpace(output))
+	}
+	return nil
+}
+
+// replaceInstance swaps tables of the live database with the imported ones, then the volume with the extracted one.
+// Previous tables are put back when the volume cannot be replaced, so the instance is left either restored or unchanged.
+func replaceInstance(c *cli.Context, cfg *config.Config, store *backupstore.Store, manifest *backupstore.Manifest) error {
+	dialect, err := database.DialectOf(cfg)
+	if err != nil {
+		return err
+	}
+
+	db, err := database.Connect(cfg, "")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	credentials := cfg.Instances.Credentials[manifest.Identifier]
+	restoreDB, previousDB := restoreDatabases(credentials)
+	if err := database.ExecStatements(db, append(dialect.EnsureStatements(credentials), dialect.ScratchStatements(previousDB)...)); err != nil {
+		return err
+	}
+	current, err := database.ListTables(db, credentials.DBName)
+	if err != nil {
+		return err
+	}
+	restored, err := database.ListTables(db, restoreDB)
+	if err != nil {
+		return err
+	}
+	if err := database.ExecStatements(db, dialect.SwapTablesStatements(credentials.DBName, current, restoreDB, restored, previousDB)); err != nil {
+		return err
+	}
+
+	volumePath := cfg.InstanceVolumePath(manifest.Identifier)
+	restorePath, previousPath := restorePaths(cfg, manifest.Identifier)
+	if err := replaceDirectory(volumePath, restorePath, previousPath); err != nil {
+		rollbackErr := database.ExecStatements(db, dialect.SwapTablesStatements(credentials.DBName, restored, previousDB, current, restoreDB))
+		return errors.Join(err, rollbackErr)
+	}
+
+	return errors.Join(
+		database.ExecStatements(db, dialect.DropDatabaseStatements(previousDB)),
+		database.ExecStatements(db, dialect.DropDatabaseStatements(restoreDB)),
+		utils.RemoveDirectory(previousPath, true),
+	)
+}
+
+// replaceDirectory renames replacement to path, the current directory being moved to previous, or back on failure
+func replaceDirectory(path string, replacement string, previous string) error {
+	if err := utils.RemoveDirectory(previous, true); err != nil {
+		return err
+	}
+	exists, err := utils.DirectoryExists(path)
+	if err != nil {
+		return err
+	}
+	if exists {
+		if err := os.Rename(path, previous); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(replacement, path); err != nil {
+		if exists {
+			return errors.Join(err, os.Rename(previous, path))
+		}
+		return err
+	}
+	return nil
+}
+
+// discardRestore removes the extracted volume and imported database of a failed restore, the live ones being kept
+func discardRestore(cfg *config.Config, manifest *backupstore.Manifest) error {
+	restorePath, _ := restorePaths(cfg, manifest.Identifier)
+	if err := utils.RemoveDirectory(restorePath, true); err != nil {
+		return err
+	}
+	credentials, exists := cfg.Instances.Credentials[manifest.Identifier]
+	if !exists {
+		return nil
+	}
+
+	dialect, err := database.DialectOf(cfg)
+	if err != nil {
+		return err
+	}
+	db, err := database.Connect(cfg, "")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	restoreDB, _ := restoreDatabases(credentials)
+	return database.ExecStatements(db, dialect.DropDatabaseStatements(restoreDB))
+}
+
+// writeCompose renders the compose file from the current configuration, the backed up one being kept for reference
+func writeCompose(c *cli.Context, cfg *config.Config, store *backupstore.Store, manifest *backupstore.Manifest) error {
+	return replicate.WriteInstanceCompose(cfg, manifest.Identifier)
+}
+
+func startInstance(c *cli.Context, cfg *config.Config, store *backupstore.Store, manifest *backupstore.Manifest) error {
+	_, err := utils.UpComposeFile(cfg.InstanceComposePath(manifest.Identifier))
+	return err
+}
+
+// flushObjectCache drops keys cached before the restore, selective flush limiting it to the instance Redis database
+func flushObjectCache(c *cli.Context, cfg *config.Config, store *backupstore.Store, manifest *backupstore.Manifest) error {
+	if !cfg.RedisEnabled() {
+		return utils.SkippedError{Msg: "object cache disabled"}
+	}
+	if res, err := utils.ExecDockerCmd(cfg.InstanceContainerName(manifest.Identifier), container.ExecOptions{
+		User: fmt.Sprintf("%d:%d", cfg.Uid, cfg.Gid),
+		Cmd:  []string{"bash", "-c", "wp cache flush"},
+	}, nil); err != nil {
+		return fmt.Errorf("error flushing object cache: %v - Details: %s", err, res)
+	}
+	return nil
+}
+
+func updateHostsFile(c *cli.Context, cfg *config.Config, store *backupstore.Store, manifest *backupstore.Manifest) error {
+	return hostsfile.Sync(cfg)
+}
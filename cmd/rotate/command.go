@@ -0,0 +1,289 @@
+package rotate
+
+import (
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+	"github.com/quix-labs/multipress/cmd/deploy"
+	"github.com/quix-labs/multipress/cmd/replicate"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/database"
+	"github.com/quix-labs/multipress/inventory"
+	"github.com/quix-labs/multipress/stack"
+	"github.com/quix-labs/multipress/utils"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/sync/errgroup"
+	"os"
+	"sync"
+)
+
+func Command() *cli.Command {
+	return &cli.Command{
+		Name:      "rotate",
+		Usage:     "Rotate passwords of instances, model and MySQL root",
+		ArgsUsage: "[identifiers|model...]",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "all",
+				Usage: "Rotate all instances",
+			},
+			stack.SelectFlag(),
+			&cli.BoolFlag{
+				Name:  "admin",
+				Usage: "Rotate WordPress admin password",
+			},
+			&cli.BoolFlag{
+				Name:  "db",
+				Usage: "Rotate database user password, recreating the container",
+			},
+			&cli.BoolFlag{
+				Name:  "root",
+				Usage: "Rotate MySQL root password, recreating the MySQL container",
+			},
+			&cli.IntFlag{
+				Name:    "parallel",
+				Aliases: []string{"p"},
+				Usage:   "Maximum number of instances processed simultaneously",
+				Value:   5,
+			},
+		},
+		Action: action,
+	}
+}
+
+const configPath = "multipress.yaml"
+
+type rotation struct {
+	Target string
+	Secret string
+	Err    error
+}
+
+// cfgLock guards configuration mutations, persisted after each rotation so it always matches applied passwords
+var cfgLock = new(sync.Mutex)
+
+func action(c *cli.Context) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	targets, err := parseTargets(c, cfg)
+	if err != nil {
+		fmt.Println("Usage: rotate [identifiers|model...|--all|--select tag=value] [--admin] [--db] [--root]")
+		fmt.Println(err)
+		return err
+	}
+
+	// Rotate both instance passwords when nothing specific is asked
+	rotateAdmin, rotateDb := c.Bool("admin"), c.Bool("db")
+	if !rotateAdmin && !rotateDb && !c.Bool("root") {
+		rotateAdmin, rotateDb = true, true
+	}
+
+	var rotations []rotation
+	if c.Bool("root") {
+		_ = utils.Spin(utils.SpinOptions{Label: "Rotating MySQL root password"}, func() error {
+			err := rotateRootPassword(cfg)
+			rotations = append(rotations, rotation{Target: "mysql", Secret: "root password", Err: err})
+			return err
+		})
+	}
+
+	if len(targets) > 0 {
+		results := make([][]rotation, len(targets))
+		_ = utils.Spin(utils.SpinOptions{Label: fmt.Sprintf("Rotating passwords of %d target(s)", len(targets))}, func() error {
+			var g errgroup.Group
+			g.SetLimit(max(c.Int("parallel"), 1))
+			for i, target := range targets {
+				g.Go(func() error {
+					if rotateAdmin {
+						results[i] = append(results[i], rotation{target, "admin password", rotateAdminPassword(cfg, target)})
+					}
+					if rotateDb {
+						results[i] = append(results[i], rotation{target, "database password", rotateDatabasePassword(cfg, target)})
+					}
+					return nil
+				})
+			}
+			_ = g.Wait()
+
+			for _, result := range results {
+				for _, r := range result {
+					if r.Err != nil {
+						return errors.New("some rotations failed")
+					}
+				}
+			}
+			return nil
+		})
+		for _, result := range results {
+			rotations = append(rotations, result...)
+		}
+	}
+
+	utils.PrintSeparator("Report", '═')
+	t := table.NewWriter()
+	t.SetStyle(table.StyleLight)
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Target", "Secret", "Status", "Error"})
+	failed := 0
+	for _, r := range rotations {
+		status, details := text.FgGreen.Sprint("ROTATED"), ""
+		if r.Err != nil {
+			failed++
+			status, details = text.FgRed.Sprint("FAIL"), r.Err.Error()
+		}
+		t.AppendRow(table.Row{r.Target, r.Secret, status, details})
+	}
+	t.Render()
+	fmt.Println("New passwords are available with 'multipress list --with-secrets'")
+
+	if failed > 0 {
+		return fmt.Errorf("%d rotation(s) failed", failed)
+	}
+	return nil
+}
+
+func parseTargets(c *cli.Context, cfg *config.Config) ([]string, error) {
+	if c.Bool("all") || c.IsSet("select") {
+		return stack.SelectedInstances(c, cfg, nil)
+	}
+
+	targets := c.Args().Slice()
+	for _, target := range targets {
+		if _, err := cfg.TargetCredentials(target); err != nil {
+			return nil, err
+		}
+	}
+	if len(targets) == 0 && !c.Bool("root") {
+		return nil, errors.New("no target defined")
+	}
+	return targets, nil
+}
+
+func rotateAdminPassword(cfg *config.Config, target string) error {
+	cfgLock.Lock()
+	credentials, err := cfg.TargetCredentials(target)
+	cfgLock.Unlock()
+	if err != nil {
+		return err
+	}
+	containerName, err := cfg.TargetContainerName(target)
+	if err != nil {
+		return err
+	}
+
+	credentials.Password = utils.GenerateSecurePassword(16)
+	if res, err := utils.ExecDockerCmd(containerName, container.ExecOptions{
+		User: fmt.Sprintf("%d:%d", cfg.Uid, cfg.Gid),
+		Cmd:  []string{"wp", "user", "update", credentials.Username, "--user_pass=" + credentials.Password, "--skip-email"},
+	}, nil); err != nil {
+		return fmt.Errorf("%w - Details: %s", err, res)
+	}
+
+	return persist(cfg, target, func(current *config.CredentialsConfig) {
+		current.Password = credentials.Password
+	})
+}
+
+func rotateDatabasePassword(cfg *config.Config, target string) error {
+	cfgLock.Lock()
+	credentials, err := cfg.TargetCredentials(target)
+	cfgLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	dialect, err := database.DialectOf(cfg)
+	if err != nil {
+		return err
+	}
+
+	db, err := database.Connect(cfg, "")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	credentials.DBPassword = utils.GenerateSecurePassword(16)
+	if err := database.ExecStatements(db, dialect.AlterPasswordStatements(credentials.DBUser, credentials.DBPassword)); err != nil {
+		return err
+	}
+
+	if err := persist(cfg, target, func(current *config.CredentialsConfig) {
+		current.DBPassword = credentials.DBPassword
+	}); err != nil {
+		return err
+	}
+
+	// WORDPRESS_DB_PASSWORD changes, so compose recreates the container
+	cfgLock.Lock()
+	composePath := cfg.InstanceComposePath(target)
+	if target == config.ModelTarget {
+		composePath = cfg.ModelComposePath()
+		err = deploy.WriteModelCompose(cfg)
+	} else {
+		err = replicate.WriteInstanceCompose(cfg, target)
+	}
+	cfgLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	_, err = utils.UpComposeFile(composePath)
+	return err
+}
+
+func rotateRootPassword(cfg *config.Config) error {
+	dialect, err := database.DialectOf(cfg)
+	if err != nil {
+		return err
+	}
+
+	db, err := database.Connect(cfg, "")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	password := utils.GenerateSecurePassword(16)
+	if err := database.ExecStatements(db, dialect.AlterRootPasswordStatements(password)); err != nil {
+		return err
+	}
+
+	cfg.MySql.RootPassword = password
+	if err := cfg.SaveAs(configPath); err != nil {
+		return err
+	}
+
+	// Healthcheck embeds the root password
+	if err := deploy.WriteMysqlCompose(cfg); err != nil {
+		return err
+	}
+	_, err = utils.UpComposeFile(cfg.MysqlComposePath())
+	return err
+}
+
+// persist applies update on the current target credentials, then saves configuration and CSV
+func persist(cfg *config.Config, target string, update func(current *config.CredentialsConfig)) error {
+	cfgLock.Lock()
+	defer cfgLock.Unlock()
+
+	credentials, err := cfg.TargetCredentials(target)
+	if err != nil {
+		return err
+	}
+	update(&credentials)
+	if err := cfg.SetTargetCredentials(target, credentials); err != nil {
+		return err
+	}
+
+	if err := cfg.SaveAs(configPath); err != nil {
+		return err
+	}
+	return inventory.WriteCredentialsCsv(cfg)
+}
@@ -0,0 +1,52 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/utils"
+	"github.com/urfave/cli/v2"
+)
+
+func Command() *cli.Command {
+	return &cli.Command{
+		Name:      "shell",
+		Usage:     "Open an interactive shell into an instance",
+		ArgsUsage: "<identifier|model> [-- command...]",
+		Action:    action,
+	}
+}
+
+func action(c *cli.Context) error {
+	configPath := "multipress.yaml"
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	if c.Args().Len() < 1 {
+		fmt.Println("Usage: shell <identifier|model> [-- command...]")
+		return errors.New("invalid argument")
+	}
+
+	containerName, err := cfg.TargetContainerName(c.Args().First())
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	cmd := c.Args().Tail()
+	if len(cmd) > 0 && cmd[0] == "--" {
+		cmd = cmd[1:]
+	}
+	if len(cmd) == 0 {
+		cmd = []string{"bash"}
+	}
+
+	return utils.InteractiveDockerCmd(containerName, container.ExecOptions{
+		User: fmt.Sprintf("%d:%d", cfg.Uid, cfg.Gid),
+		Cmd:  cmd,
+	})
+}
@@ -0,0 +1,209 @@
+package status
+
+import (
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+	"github.com/quix-labs/multipress/cmd/daemon"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/stack"
+	"github.com/quix-labs/multipress/utils"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/sync/errgroup"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func Command() *cli.Command {
+	return &cli.Command{
+		Name:      "status",
+		Usage:     "Show services and instances status",
+		ArgsUsage: "[identifiers...]",
+		Flags: []cli.Flag{
+			stack.SelectFlag(),
+		},
+		Action: action,
+	}
+}
+
+type containerStatus struct {
+	State   string
+	Health  string
+	Details string
+}
+
+func action(c *cli.Context) error {
+	configPath := "multipress.yaml"
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	identifiers, err := stack.SelectedInstances(c, cfg, c.Args().Slice())
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	// Only show infrastructure when no instance filter is given
+	var services []string
+	if c.Args().Len() == 0 && !c.IsSet("select") {
+		services = cfg.Services()
+	}
+
+	servicesStatus := make([]containerStatus, len(services))
+	instancesStatus := make([]containerStatus, len(identifiers))
+
+	var g errgroup.Group
+	for i, service := range services {
+		g.Go(func() error {
+			containerName, err := cfg.ServiceContainerName(service)
+			if err != nil {
+				return err
+			}
+			servicesStatus[i] = inspect(containerName)
+			if service == "redis" && cfg.RedisEnabled() {
+				servicesStatus[i].Details = redisDetails(containerName)
+			}
+			return nil
+		})
+	}
+	for i, identifier := range identifiers {
+		g.Go(func() error {
+			instancesStatus[i] = inspect(cfg.InstanceContainerName(identifier))
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	if len(services) > 0 {
+		utils.PrintSeparator("Services", '═')
+		t := newTable()
+		t.AppendHeader(table.Row{"Service", "State", "Health", "Details"})
+		for i, service := range services {
+			t.AppendRow(table.Row{service, servicesStatus[i].State, servicesStatus[i].Health, servicesStatus[i].Details})
+		}
+		t.Render()
+	}
+
+	if len(services) > 0 && cfg.Schedule != nil {
+		if err := printSchedule(cfg); err != nil {
+			fmt.Println(err)
+			return err
+		}
+	}
+
+	utils.PrintSeparator("Instances", '═')
+	t := newTable()
+	t.AppendHeader(table.Row{"Instance", "URL", "State", "Health", "Owner", "Tags", "Expires at"})
+	for i, identifier := range identifiers {
+		metadata := cfg.Instances.Metadata[identifier]
+		expiresAt := ""
+		if !metadata.ExpiresAt.IsZero() {
+			expiresAt = metadata.ExpiresAt.Format(time.DateTime)
+		}
+		t.AppendRow(table.Row{
+			identifier,
+			cfg.InstanceUrl(identifier),
+			instancesStatus[i].State,
+			instancesStatus[i].Health,
+			metadata.Owner,
+			strings.Join(metadata.TagList(), ", "),
+			expiresAt,
+		})
+	}
+	t.Render()
+
+	return nil
+}
+
+// printSchedule shows the last run of jobs of 'multipress daemon' and their next run
+func printSchedule(cfg *config.Config) error {
+	runs, err := daemon.LoadHistory(cfg)
+	if err != nil {
+		return err
+	}
+	lastRuns := daemon.LastRuns(runs)
+
+	now := time.Now()
+	utils.PrintSeparator("Scheduled jobs", '═')
+	t := newTable()
+	t.AppendHeader(table.Row{"Job", "Schedule", "Last run", "Duration", "Result", "Next run"})
+	for _, job := range daemon.ScheduledJobs(cfg.Schedule) {
+		row := table.Row{job.Name, job.Spec, "never", "", "", ""}
+		if job.Spec == "" {
+			row[1] = text.FgHiBlack.Sprint("disabled")
+		} else if next, err := daemon.NextRun(job.Spec, now); err == nil {
+			row[5] = next.Format(time.DateTime)
+		}
+
+		if run, exists := lastRuns[job.Name]; exists {
+			row[2] = run.Start.Format(time.DateTime)
+			row[3] = run.End.Sub(run.Start).Truncate(time.Second).String()
+			row[4] = text.FgGreen.Sprint("OK") + " " + run.Summary
+			if run.Failed() {
+				row[4] = text.FgRed.Sprint("FAILED") + " " + run.Error
+			}
+		}
+		t.AppendRow(row)
+	}
+	t.Render()
+	return nil
+}
+
+func newTable() table.Writer {
+	t := table.NewWriter()
+	t.SetStyle(table.StyleLight)
+	t.SetOutputMirror(os.Stdout)
+	return t
+}
+
+func inspect(containerName string) containerStatus {
+	running, health, err := utils.DockerContainerState(containerName)
+	if err != nil {
+		return containerStatus{State: text.FgHiBlack.Sprint("not deployed")}
+	}
+
+	status := containerStatus{State: text.FgRed.Sprint("stopped"), Health: health}
+	if running {
+		status.State = text.FgGreen.Sprint("running")
+	}
+	switch health {
+	case "healthy":
+		status.Health = text.FgGreen.Sprint(health)
+	case "unhealthy":
+		status.Health = text.FgRed.Sprint(health)
+	}
+	return status
+}
+
+// redisDetails returns the object cache hit rate and memory, shared by model and instances
+func redisDetails(containerName string) string {
+	output, err := utils.ExecDockerCmd(containerName, container.ExecOptions{
+		Cmd: []string{"redis-cli", "INFO"},
+	}, nil)
+	if err != nil {
+		return ""
+	}
+
+	info := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		if key, value, found := strings.Cut(strings.TrimSpace(line), ":"); found {
+			info[key] = value
+		}
+	}
+
+	hits, _ := strconv.ParseFloat(info["keyspace_hits"], 64)
+	misses, _ := strconv.ParseFloat(info["keyspace_misses"], 64)
+	if hits+misses == 0 {
+		return fmt.Sprintf("no request yet, %s used", info["used_memory_human"])
+	}
+	return fmt.Sprintf("hit rate %.1f%%, %s used", 100*hits/(hits+misses), info["used_memory_human"])
+}
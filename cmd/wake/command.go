@@ -0,0 +1,111 @@
+package wake
+
+import (
+	_ "embed"
+	"fmt"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/utils"
+	"github.com/urfave/cli/v2"
+	"html/template"
+	"log"
+	"net/http"
+	"sync"
+)
+
+func Command() *cli.Command {
+	return &cli.Command{
+		Name:   "wake-server",
+		Usage:  "Serve hibernated instances, starting them on request (run by the waker container)",
+		Hidden: true,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "listen",
+				Usage: "Address to listen on",
+				Value: ":8080",
+			},
+		},
+		Action: action,
+	}
+}
+
+const configPath = "multipress.yaml"
+
+//go:embed tmpl/waking.html.tmpl
+var wakingTmpl string
+
+type server struct {
+	waking   sync.Map
+	template *template.Template
+}
+
+func action(c *cli.Context) error {
+	s := &server{template: template.Must(template.New("").Parse(wakingTmpl))}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ask", s.ask)
+	mux.HandleFunc("/", s.wake)
+
+	log.Printf("Wake-up server listening on %s", c.String("listen"))
+	return http.ListenAndServe(c.String("listen"), mux)
+}
+
+// ask is used by Caddy on-demand TLS, certificates are only allowed for known instances
+func (s *server) ask(w http.ResponseWriter, r *http.Request) {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, exists := cfg.InstanceFromHost(r.URL.Query().Get("domain")); !exists {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *server) wake(w http.ResponseWriter, r *http.Request) {
+	// Reload on each request to know instances created after startup
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	identifier, exists := cfg.InstanceFromHost(r.Host)
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+
+	containerName := cfg.InstanceContainerName(identifier)
+	running, health, err := utils.DockerContainerState(containerName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	// Caddy routes the host to the instance as soon as it notices it is running
+	if running && (health == "" || health == "healthy") {
+		http.Redirect(w, r, r.URL.RequestURI(), http.StatusTemporaryRedirect)
+		return
+	}
+
+	if !running {
+		if _, alreadyWaking := s.waking.LoadOrStore(identifier, true); !alreadyWaking {
+			go func() {
+				defer s.waking.Delete(identifier)
+				log.Printf("Waking up %s", identifier)
+				if err := utils.StartDockerContainer(containerName); err != nil {
+					log.Printf("Failed to wake up %s: %v", identifier, err)
+				}
+			}()
+		}
+	}
+
+	w.Header().Set("Retry-After", "2")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	if err := s.template.Execute(w, map[string]string{"Identifier": identifier}); err != nil {
+		log.Println(fmt.Errorf("failed to render waking page: %w", err))
+	}
+}
@@ -0,0 +1,172 @@
+package wp
+
+import (
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/stack"
+	"github.com/quix-labs/multipress/utils"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/sync/errgroup"
+	"os"
+	"strings"
+)
+
+func Command() *cli.Command {
+	return &cli.Command{
+		Name:      "wp",
+		Usage:     "Run wp-cli on one, some or all instances",
+		ArgsUsage: "<identifier|model|identifier,identifier...|--all|--select tag=value> -- <wp-cli arguments...>",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "all",
+				Usage: "Run on all instances",
+			},
+			stack.SelectFlag(),
+			&cli.IntFlag{
+				Name:    "parallel",
+				Aliases: []string{"p"},
+				Usage:   "Maximum number of instances processed simultaneously",
+				Value:   5,
+			},
+		},
+		Action: action,
+	}
+}
+
+type result struct {
+	Target string
+	Output string
+	Err    error
+}
+
+func action(c *cli.Context) error {
+	configPath := "multipress.yaml"
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	targets, wpArgs, err := parseArgs(c, cfg)
+	if err != nil {
+		fmt.Println("Usage: wp <identifier|model|identifier,identifier...|--all|--select tag=value> -- <wp-cli arguments...>")
+		fmt.Println(err)
+		return err
+	}
+
+	results := make([]result, len(targets))
+	label := fmt.Sprintf("Running wp %s on %d target(s)", strings.Join(wpArgs, " "), len(targets))
+	_ = utils.Spin(utils.SpinOptions{Label: label}, func() error {
+		var g errgroup.Group
+		g.SetLimit(max(c.Int("parallel"), 1))
+		for i, target := range targets {
+			g.Go(func() error {
+				output, err := runWpCli(cfg, target, wpArgs)
+				results[i] = result{Target: target, Output: output, Err: err}
+				return nil
+			})
+		}
+		_ = g.Wait()
+
+		if failed := countFailed(results); failed > 0 {
+			return fmt.Errorf("%d/%d failed", failed, len(results))
+		}
+		return nil
+	})
+
+	printReport(results)
+
+	if failed := countFailed(results); failed > 0 {
+		return fmt.Errorf("wp-cli failed on %d target(s)", failed)
+	}
+	return nil
+}
+
+func parseArgs(c *cli.Context, cfg *config.Config) ([]string, []string, error) {
+	args := c.Args().Slice()
+
+	var targets []string
+	if c.Bool("all") || c.IsSet("select") {
+		var err error
+		if targets, err = stack.SelectedInstances(c, cfg, nil); err != nil {
+			return nil, nil, err
+		}
+		if len(targets) == 0 {
+			return nil, nil, errors.New("no instances found")
+		}
+	} else {
+		if len(args) == 0 {
+			return nil, nil, errors.New("target not defined")
+		}
+		for _, target := range strings.Split(args[0], ",") {
+			target = strings.TrimSpace(target)
+			if _, err := cfg.TargetContainerName(target); err != nil {
+				return nil, nil, err
+			}
+			targets = append(targets, target)
+		}
+		args = args[1:]
+	}
+
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return nil, nil, errors.New("wp-cli arguments not defined")
+	}
+	return targets, args, nil
+}
+
+func runWpCli(cfg *config.Config, target string, wpArgs []string) (string, error) {
+	containerName, err := cfg.TargetContainerName(target)
+	if err != nil {
+		return "", err
+	}
+
+	return utils.ExecDockerCmd(containerName, container.ExecOptions{
+		User: fmt.Sprintf("%d:%d", cfg.Uid, cfg.Gid),
+		Cmd:  append([]string{"wp"}, wpArgs...),
+	}, nil)
+}
+
+func countFailed(results []result) int {
+	failed := 0
+	for _, res := range results {
+		if res.Err != nil {
+			failed++
+		}
+	}
+	return failed
+}
+
+func printReport(results []result) {
+	for _, res := range results {
+		utils.PrintSeparator(res.Target, '─')
+		fmt.Print(res.Output)
+		if res.Output != "" && !strings.HasSuffix(res.Output, "\n") {
+			fmt.Println()
+		}
+	}
+
+	utils.PrintSeparator("Report", '═')
+	t := table.NewWriter()
+	t.SetStyle(table.StyleLight)
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Target", "Status", "Exit code", "Error"})
+	for _, res := range results {
+		status, exitCode, details := text.FgGreen.Sprint("OK"), "0", ""
+		if res.Err != nil {
+			status, exitCode, details = text.FgRed.Sprint("FAIL"), "---", res.Err.Error()
+			var exitErr utils.ExecExitError
+			if errors.As(res.Err, &exitErr) {
+				exitCode, details = fmt.Sprint(exitErr.ExitCode), ""
+			}
+		}
+		t.AppendRow(table.Row{res.Target, status, exitCode, details})
+	}
+	t.Render()
+}
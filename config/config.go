@@ -1,14 +1,23 @@
 package config
 
 import (
+	"cmp"
 	"fmt"
+	"github.com/quix-labs/multipress/utils"
 	"gopkg.in/yaml.v3"
+	"net"
 	"os"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"sync/atomic"
+	"time"
 )
 
+// ModelTarget designates the model where commands accept an instance identifier
+const ModelTarget = "model"
+
 type CredentialsConfig struct {
 	DBName     string `yaml:"dbname,omitempty"`
 	DBUser     string `yaml:"dbuser,omitempty"`
@@ -17,21 +26,65 @@ type CredentialsConfig struct {
 	Username string `yaml:"username,omitempty"`
 	Password string `yaml:"password,omitempty"`
 	Email    string `yaml:"email,omitempty"`
+
+	// RedisDatabase isolates the object cache of an instance, the model using the first one
+	RedisDatabase int `yaml:"redis-database,omitempty"`
+}
+
+type InstanceMetadata struct {
+	Owner      string            `yaml:"owner,omitempty"`
+	OwnerEmail string            `yaml:"owner-email,omitempty"`
+	Notes      string            `yaml:"notes,omitempty"`
+	Tags       map[string]string `yaml:"tags,omitempty"`
+	CreatedAt  time.Time         `yaml:"created-at,omitempty"`
+	ExpiresAt  time.Time         `yaml:"expires-at,omitempty"`
+}
+
+// TagList returns tags formatted as "key=value", sorted by key
+func (m InstanceMetadata) TagList() []string {
+	tags := make([]string, 0, len(m.Tags))
+	for key, value := range m.Tags {
+		tags = append(tags, key+"="+value)
+	}
+	slices.Sort(tags)
+	return tags
+}
+
+// Matches reports whether metadata satisfies all selectors, formatted as "tag=value" or "tag" for presence
+func (m InstanceMetadata) Matches(selectors []string) bool {
+	for _, selector := range selectors {
+		key, value, hasValue := strings.Cut(selector, "=")
+		tagValue, exists := m.Tags[key]
+		if !exists || (hasValue && tagValue != value) {
+			return false
+		}
+	}
+	return true
 }
 
 type InstancesConfig struct {
 	_counter    *atomic.Uint64               `yaml:"-"`
 	Resources   ResourcesConfig              `yaml:"resources"`
 	Credentials map[string]CredentialsConfig `yaml:"credentials"`
+	Metadata    map[string]InstanceMetadata  `yaml:"metadata,omitempty"`
 }
 
 type ResourcesConfig struct {
 	Memory string `yaml:"memory,omitempty"`
 }
 
+// Database engines, all served by the mysql service
+const (
+	EngineMysql   = "mysql"
+	EngineMariadb = "mariadb"
+	EnginePercona = "percona"
+)
+
 type MysqlConfig struct {
-	Resources    ResourcesConfig `yaml:"resources,omitempty"`
-	RootPassword string          `yaml:"root-password,omitempty"`
+	Resources ResourcesConfig `yaml:"resources,omitempty"`
+	// Engine is mysql, mariadb or percona, mysql when empty
+	Engine       string `yaml:"engine,omitempty"`
+	RootPassword string `yaml:"root-password,omitempty"`
 }
 
 type ModelConfig struct {
@@ -39,9 +92,299 @@ type ModelConfig struct {
 	Credentials CredentialsConfig `yaml:"credentials,omitempty"`
 }
 
+// TLS issuers, files being a user-supplied (wildcard) certificate
+const (
+	TLSIssuerInternal = "internal"
+	TLSIssuerAcme     = "acme"
+	TLSIssuerFiles    = "files"
+)
+
 type CaddyConfig struct {
 	Resources ResourcesConfig `yaml:"resources,omitempty"`
-	TLSIssuer string          `yaml:"tls-issuer,omitempty"`
+	// TLSIssuer is internal, acme or files
+	TLSIssuer string `yaml:"tls-issuer,omitempty"`
+
+	// CertFile and KeyFile are PEM files mounted into Caddy, used by the files issuer
+	CertFile string `yaml:"cert-file,omitempty"`
+	KeyFile  string `yaml:"key-file,omitempty"`
+
+	AcmeEmail string `yaml:"acme-email,omitempty"`
+	// AcmeCA is the ACME directory URL, e.g. a local Pebble for testing
+	AcmeCA string `yaml:"acme-ca,omitempty"`
+	// AcmeCARoot is the PEM root certificate of AcmeCA, when not publicly trusted
+	AcmeCARoot string `yaml:"acme-ca-root,omitempty"`
+
+	// DnsProvider enables DNS-01 challenge with a github.com/caddy-dns module, Caddy being rebuilt with it
+	DnsProvider string `yaml:"dns-provider,omitempty"`
+	// DnsProviderVersion pins the module of DnsProvider, e.g. v0.2.1
+	DnsProviderVersion string `yaml:"dns-provider-version,omitempty"`
+	// DnsProviderArgs are given to acme_dns, e.g. "{env.CLOUDFLARE_API_TOKEN}"
+	DnsProviderArgs string `yaml:"dns-provider-args,omitempty"`
+	// DnsEnv is the environment of Caddy, holding provider credentials
+	DnsEnv map[string]string `yaml:"dns-env,omitempty"`
+
+	// Shared joins the host-level proxy serving all projects of the host, instead of a Caddy of the project
+	Shared bool `yaml:"shared,omitempty"`
+}
+
+// SharedCaddyContainerName is the host-level proxy shared by projects
+const SharedCaddyContainerName = "multipress-caddy"
+
+const caddyAcmeCARootPath = "/certs/acme-ca-root.pem"
+
+// CaddyCertPath returns where the certificate file of project is mounted in Caddy, unique in the shared proxy
+func CaddyCertPath(project string) string {
+	return "/certs/" + project + "/cert.pem"
+}
+
+func CaddyKeyPath(project string) string {
+	return "/certs/" + project + "/key.pem"
+}
+
+// CaddyVolumesPath returns where volumes of project are mounted in Caddy, serving static files of PHP-FPM
+func CaddyVolumesPath(project string) string {
+	return "/srv/" + project + "/volumes"
+}
+
+// CaddyInternalRootPath is the root certificate of the internal issuer, kept in the caddy-data volume
+const CaddyInternalRootPath = "/data/caddy/pki/authorities/local/root.crt"
+
+// IsInternal returns whether certificates are signed by the Caddy local authority
+func (c *CaddyConfig) IsInternal() bool {
+	return c.TLSIssuer == TLSIssuerInternal
+}
+
+// TLSLabel returns the site label configuring TLS, following the site prefix (e.g. caddy.tls.issuer)
+func (c *CaddyConfig) TLSLabel() string {
+	if c.TLSIssuer == TLSIssuerFiles {
+		return "tls"
+	}
+	return "tls.issuer"
+}
+
+func (c *CaddyConfig) AcmeCARootPath() string {
+	return caddyAcmeCARootPath
+}
+
+// IsAcme reports whether certificates are ordered from an ACME CA
+func (c *CaddyConfig) IsAcme() bool {
+	return c.TLSIssuer == TLSIssuerAcme
+}
+
+// DnsModulePath returns the Go module of DnsProvider
+func (c *CaddyConfig) DnsModulePath() string {
+	return "github.com/caddy-dns/" + c.DnsProvider
+}
+
+// DnsModule returns the pinned Caddy module of DnsProvider, as given to xcaddy
+func (c *CaddyConfig) DnsModule() string {
+	return c.DnsModulePath() + "@" + c.DnsProviderVersion
+}
+
+// DockerProxyModule returns the pinned caddy-docker-proxy module built with DnsProvider, matching the caddy image
+func (c *CaddyConfig) DockerProxyModule() string {
+	return "github.com/lucaslorentz/caddy-docker-proxy/v2@v2.9.1"
+}
+
+type SmtpConfig struct {
+	Host     string `yaml:"host,omitempty"`
+	Port     int    `yaml:"port,omitempty"`
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	From     string `yaml:"from,omitempty"`
+	// Encryption is none, starttls or tls (implicit)
+	Encryption string `yaml:"encryption,omitempty"`
+}
+
+type NotifyConfig struct {
+	Smtp         SmtpConfig `yaml:"smtp"`
+	Subject      string     `yaml:"subject,omitempty"`
+	TextTemplate string     `yaml:"text-template,omitempty"`
+	HtmlTemplate string     `yaml:"html-template,omitempty"`
+}
+
+// Mail modes, instances send their emails to the bundled catcher, to a real relay, or nowhere
+const (
+	MailModeCatcher = "catcher"
+	MailModeRelay   = "relay"
+	MailModeNone    = "none"
+)
+
+// AccessConfig restricts an administration service published by Caddy
+type AccessConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// PasswordHash is the bcrypt hash of Password given to Caddy, updated when Password changes
+	PasswordHash string `yaml:"password-hash,omitempty"`
+	// AllowedIps are addresses or CIDR ranges allowed to reach the service, everyone when empty
+	AllowedIps []string `yaml:"allowed-ips,omitempty"`
+}
+
+// EnsurePasswordHash updates PasswordHash when it does not match Password, reporting whether it changed
+func (a *AccessConfig) EnsurePasswordHash() (bool, error) {
+	if a.PasswordHash != "" && utils.PasswordMatchesHash(a.Password, a.PasswordHash) {
+		return false, nil
+	}
+	hash, err := utils.HashPassword(a.Password)
+	if err != nil {
+		return false, err
+	}
+	a.PasswordHash = hash
+	return true, nil
+}
+
+// ComposePasswordHash escapes PasswordHash from compose variables interpolation
+func (a *AccessConfig) ComposePasswordHash() string {
+	return strings.ReplaceAll(a.PasswordHash, "$", "$$")
+}
+
+type MailConfig struct {
+	Resources ResourcesConfig `yaml:"resources,omitempty"`
+	// Mode is catcher (bundled Mailpit), relay (real SMTP server) or none
+	Mode  string     `yaml:"mode,omitempty"`
+	Relay SmtpConfig `yaml:"relay,omitempty"`
+	// Access restricts the web interface of the catcher, which shows password reset links, not published when disabled
+	Access *AccessConfig `yaml:"access,omitempty"`
+}
+
+// UiEnabled reports whether the web interface of the catcher is published
+func (m *MailConfig) UiEnabled() bool {
+	return m.Mode == MailModeCatcher && (m.Access == nil || m.Access.Enabled)
+}
+
+type ImageConfig struct {
+	Image string `yaml:"image,omitempty"`
+	Tag   string `yaml:"tag,omitempty"`
+	// Digest pins the exact build of the tag, recorded by 'multipress images --record'
+	Digest string `yaml:"digest,omitempty"`
+}
+
+// Reference returns the image reference as used by Docker, the digest taking precedence over the tag
+func (i ImageConfig) Reference() string {
+	reference := i.Image
+	if i.Tag != "" {
+		reference += ":" + i.Tag
+	}
+	if i.Digest != "" {
+		reference += "@" + i.Digest
+	}
+	return reference
+}
+
+type RedisConfig struct {
+	Resources ResourcesConfig `yaml:"resources,omitempty"`
+	Enabled   bool            `yaml:"enabled"`
+	// MaxMemory bounds the cache, least recently used keys being evicted
+	MaxMemory string `yaml:"max-memory,omitempty"`
+}
+
+// PHP variants, apache serves HTTP itself while fpm is served by Caddy through FastCGI
+const (
+	PhpVariantApache = "apache"
+	PhpVariantFpm    = "fpm"
+)
+
+type PhpConfig struct {
+	Version string `yaml:"version,omitempty"`
+	// Variant is apache or fpm
+	Variant    string            `yaml:"variant,omitempty"`
+	Extensions []string          `yaml:"extensions,omitempty"`
+	Ini        map[string]string `yaml:"ini,omitempty"`
+	// WpCli is the path of a wp-cli.phar vendored in the project, downloaded at build time when empty
+	WpCli string `yaml:"wp-cli,omitempty"`
+}
+
+// IniLines returns ini overrides as sorted "key = value" lines
+func (p *PhpConfig) IniLines() []string {
+	lines := make([]string, 0, len(p.Ini))
+	for key, value := range p.Ini {
+		lines = append(lines, key+" = "+value)
+	}
+	slices.Sort(lines)
+	return lines
+}
+
+func (p *PhpConfig) IsFpm() bool {
+	return p.Variant == PhpVariantFpm
+}
+
+// HostsConfig resolves project hostnames without public DNS, through the hosts file or a DNS responder
+type HostsConfig struct {
+	// Ip is where hostnames resolve, the address of Caddy
+	Ip string `yaml:"ip,omitempty"`
+	// File holds the managed block, kept in sync by replicate, expire and destroy, empty when not written
+	File string `yaml:"file,omitempty"`
+	// Dns enables the responder of *.<base-domain>, listening on DnsListen
+	Dns       bool   `yaml:"dns,omitempty"`
+	DnsListen string `yaml:"dns-listen,omitempty"`
+}
+
+// BackupEncryptionConfig encrypts stored files and manifests of backups with age
+type BackupEncryptionConfig struct {
+	// Recipients are age public keys (age1...), backups being readable only with their private keys
+	Recipients []string `yaml:"recipients,omitempty"`
+	// Passphrase protects a key generated in the store, MULTIPRESS_BACKUP_PASSPHRASE being used when empty
+	Passphrase string `yaml:"passphrase,omitempty"`
+	// NameKey keys names of stored files encrypted to recipients, generated on first use.
+	// With a passphrase only, names are keyed by the key of the store.
+	NameKey string `yaml:"name-key,omitempty"`
+}
+
+// EnsureNameKey generates NameKey when backups are encrypted to recipients, reporting whether it changed
+func (e *BackupEncryptionConfig) EnsureNameKey() bool {
+	if e.NameKey != "" || len(e.Recipients) == 0 {
+		return false
+	}
+	e.NameKey = utils.GenerateSecurePassword(64)
+	return true
+}
+
+// BackupTargetConfig is an offsite copy of backups, synchronized after each backup
+type BackupTargetConfig struct {
+	Name string `yaml:"name"`
+	// Type is local, s3 or sftp
+	Type string `yaml:"type"`
+	// Path is the directory of local and sftp targets, the key prefix of s3 ones
+	Path string `yaml:"path,omitempty"`
+	// Keep is the number of most recent backups kept on the target, all when zero
+	Keep int `yaml:"keep,omitempty"`
+
+	// Endpoint is the host[:port] of s3 (s3.amazonaws.com when empty) and sftp targets
+	Endpoint string `yaml:"endpoint,omitempty"`
+
+	// Bucket, Region, keys (AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY when empty) and addressing of s3 targets
+	Bucket    string `yaml:"bucket,omitempty"`
+	Region    string `yaml:"region,omitempty"`
+	AccessKey string `yaml:"access-key,omitempty"`
+	SecretKey string `yaml:"secret-key,omitempty"`
+	PathStyle bool   `yaml:"path-style,omitempty"`
+	// Insecure uses plain HTTP, e.g. for a local MinIO
+	Insecure bool `yaml:"insecure,omitempty"`
+
+	// User, Password or KeyFile, and KnownHosts (~/.ssh/known_hosts when empty) of sftp targets
+	User       string `yaml:"user,omitempty"`
+	Password   string `yaml:"password,omitempty"`
+	KeyFile    string `yaml:"key-file,omitempty"`
+	KnownHosts string `yaml:"known-hosts,omitempty"`
+}
+
+// ScheduleConfig holds cron expressions of jobs run by 'multipress daemon', empty ones being disabled
+type ScheduleConfig struct {
+	Backup    string `yaml:"backup,omitempty"`
+	Prune     string `yaml:"prune,omitempty"`
+	Expire    string `yaml:"expire,omitempty"`
+	Health    string `yaml:"health,omitempty"`
+	Hibernate string `yaml:"hibernate,omitempty"`
+
+	// KeepBackups is the number of most recent backups kept by prune
+	KeepBackups int `yaml:"keep-backups,omitempty"`
+	// ExpireBackup backups expired instances before destroying them
+	ExpireBackup bool `yaml:"expire-backup,omitempty"`
+}
+
+type HibernationConfig struct {
+	IdleTimeout string `yaml:"idle-timeout,omitempty"`
 }
 
 type Config struct {
@@ -54,6 +397,23 @@ type Config struct {
 	MySql     *MysqlConfig     `yaml:"mysql,omitempty"`
 	Model     *ModelConfig     `yaml:"model,omitempty"`
 	Instances *InstancesConfig `yaml:"instances,omitempty"`
+	Mail      *MailConfig      `yaml:"mail,omitempty"`
+	Php       *PhpConfig       `yaml:"php,omitempty"`
+	Redis     *RedisConfig     `yaml:"redis,omitempty"`
+
+	PhpMyAdmin *AccessConfig `yaml:"phpmyadmin,omitempty"`
+	Backups    *AccessConfig `yaml:"backups,omitempty"`
+
+	// Images are indexed by ImageNames, unset ones keep images of projects created before pinning
+	Images map[string]ImageConfig `yaml:"images,omitempty"`
+
+	Hibernation *HibernationConfig `yaml:"hibernation,omitempty"`
+	Notify      *NotifyConfig      `yaml:"notify,omitempty"`
+	Hosts       *HostsConfig       `yaml:"hosts,omitempty"`
+	Schedule    *ScheduleConfig    `yaml:"schedule,omitempty"`
+
+	BackupEncryption *BackupEncryptionConfig `yaml:"backup-encryption,omitempty"`
+	BackupTargets    []BackupTargetConfig    `yaml:"backup-targets,omitempty"`
 }
 
 func (cfg *Config) VolumePath() string {
@@ -64,14 +424,46 @@ func (cfg *Config) BackupsPath() string {
 	return "./backups" // Important keep ./ or use absolute
 }
 
+// BackupsStorePath holds files of all backups, deduplicated by content
+func (cfg *Config) BackupsStorePath() string {
+	return cfg.BackupsPath() + "/store"
+}
+
+func (cfg *Config) CredentialsCsvPath() string {
+	return "instance-credentials.csv"
+}
+
+func (cfg *Config) HibernationStatePath() string {
+	return "./hibernation.json"
+}
+
+func (cfg *Config) DaemonStatePath() string {
+	return "./daemon.json"
+}
+
 func (cfg *Config) NetworkName() string {
 	return cfg.Project + "-network"
 }
 
 func (cfg *Config) CaddyContainerName() string {
+	if cfg.Caddy != nil && cfg.Caddy.Shared {
+		return SharedCaddyContainerName
+	}
 	return cfg.Project + "-caddy"
 }
 
+// CaddyTLSLabelValue returns the value of the TLSLabel of project sites
+func (cfg *Config) CaddyTLSLabelValue() string {
+	if cfg.Caddy.TLSIssuer == TLSIssuerFiles {
+		return CaddyCertPath(cfg.Project) + " " + CaddyKeyPath(cfg.Project)
+	}
+	return cfg.Caddy.TLSIssuer
+}
+
+func (cfg *Config) CaddyVolumesPath() string {
+	return CaddyVolumesPath(cfg.Project)
+}
+
 func (cfg *Config) BackupsContainerName() string {
 	return cfg.Project + "-backups"
 }
@@ -88,6 +480,68 @@ func (cfg *Config) ModelContainerName() string {
 	return cfg.Project + "-model"
 }
 
+func (cfg *Config) WakerContainerName() string {
+	return cfg.Project + "-waker"
+}
+
+func (cfg *Config) MailContainerName() string {
+	return cfg.Project + "-mail"
+}
+
+func (cfg *Config) DnsContainerName() string {
+	return cfg.Project + "-dns"
+}
+
+// DnsEnabled reports whether the DNS responder of the base domain is deployed
+func (cfg *Config) DnsEnabled() bool {
+	return cfg.Hosts != nil && cfg.Hosts.Dns
+}
+
+func (cfg *Config) RedisContainerName() string {
+	return cfg.Project + "-redis"
+}
+
+// RedisEnabled reports whether model and instances use the Redis object cache
+func (cfg *Config) RedisEnabled() bool {
+	return cfg.Redis != nil && cfg.Redis.Enabled
+}
+
+// RedisPrefix isolates keys of an instance identifier or the model in the shared Redis
+func (cfg *Config) RedisPrefix(target string) string {
+	return target + ":"
+}
+
+// redisDatabases bounds the number of instances using the object cache
+const redisDatabases = 1024
+
+// RedisDatabases is the number of databases of the shared Redis, one per instance and the model
+func (cfg *Config) RedisDatabases() int {
+	return redisDatabases
+}
+
+func (cfg *Config) MailUrl() string {
+	return "https://mail." + cfg.BaseDomain
+}
+
+// MailSmtp returns the SMTP server used by WordPress, nil when mail is disabled
+func (cfg *Config) MailSmtp() *SmtpConfig {
+	if cfg.Mail == nil {
+		return nil
+	}
+	switch cfg.Mail.Mode {
+	case MailModeCatcher:
+		return &SmtpConfig{
+			Host:       cfg.MailContainerName(),
+			Port:       1025,
+			From:       "wordpress@" + cfg.BaseDomain,
+			Encryption: "none",
+		}
+	case MailModeRelay:
+		return &cfg.Mail.Relay
+	}
+	return nil
+}
+
 func (cfg *Config) BackupsUrl() string {
 	return "https://backups." + cfg.BaseDomain
 }
@@ -115,25 +569,320 @@ func (cfg *Config) MysqlVolumePath() string {
 	return cfg.VolumePath() + "/mysql"
 }
 
+func (cfg *Config) MailVolumePath() string {
+	return cfg.VolumePath() + "/mail"
+}
+
 func (cfg *Config) InstanceContainerName(identifier string) string {
 	return cfg.Project + "-" + identifier
 }
 
-func (cfg *Config) SaveAs(path string) error {
+func (cfg *Config) CaddyComposePath() string {
+	return "compose.caddy.yaml"
+}
+
+func (cfg *Config) CaddyDockerfilePath() string {
+	return "caddy.Dockerfile"
+}
+
+func (cfg *Config) MysqlComposePath() string {
+	return "compose.mysql.yaml"
+}
+
+func (cfg *Config) ModelComposePath() string {
+	return "compose.model.yaml"
+}
+
+func (cfg *Config) MailComposePath() string {
+	return "compose.mail.yaml"
+}
+
+func (cfg *Config) RedisComposePath() string {
+	return "compose.redis.yaml"
+}
+
+func (cfg *Config) DnsComposePath() string {
+	return "compose.dns.yaml"
+}
+
+func (cfg *Config) DnsCorefilePath() string {
+	return "dns.Corefile"
+}
+
+func (cfg *Config) BackupsComposePath() string {
+	return "compose.backup.yaml"
+}
+
+func (cfg *Config) WakerComposePath() string {
+	return "compose.waker.yaml"
+}
+
+func (cfg *Config) WordpressDockerfilePath() string {
+	return "wordpress.Dockerfile"
+}
+
+// PhpIniPath holds ini overrides of the php section, copied into the image next to the Dockerfile
+func (cfg *Config) PhpIniPath() string {
+	return "zz-multipress.ini"
+}
+
+func (cfg *Config) InstanceComposePath(identifier string) string {
+	return fmt.Sprintf("compose.%s.yaml", identifier)
+}
+
+// Services returns infrastructure services names, in deployment order
+func (cfg *Config) Services() []string {
+	return []string{"caddy", "dns", "mysql", "phpmyadmin", "mail", "redis", "model", "backups", "waker"}
+}
+
+func (cfg *Config) ServiceContainerName(service string) (string, error) {
+	switch service {
+	case "caddy":
+		return cfg.CaddyContainerName(), nil
+	case "dns":
+		return cfg.DnsContainerName(), nil
+	case "mysql":
+		return cfg.MysqlContainerName(), nil
+	case "phpmyadmin":
+		return cfg.PhpMyAdminContainerName(), nil
+	case "mail":
+		return cfg.MailContainerName(), nil
+	case "redis":
+		return cfg.RedisContainerName(), nil
+	case "model":
+		return cfg.ModelContainerName(), nil
+	case "backups":
+		return cfg.BackupsContainerName(), nil
+	case "waker":
+		return cfg.WakerContainerName(), nil
+	}
+	return "", fmt.Errorf("unknown service %q, expected one of %s", service, strings.Join(cfg.Services(), ", "))
+}
+
+// ImageNames returns keys of configurable images, wordpress being the base image of model and instances
+func (cfg *Config) ImageNames() []string {
+	return []string{"caddy", "caddy-builder", "caddy-base", "dns", "mysql", "phpmyadmin", "mail", "redis", "wordpress", "backups", "waker"}
+}
+
+// IsBaseImage reports whether an image is only used to build another one, run by no container
+func (cfg *Config) IsBaseImage(name string) bool {
+	return name == "wordpress" || name == "caddy-builder" || name == "caddy-base"
+}
+
+// ImageConfig returns the configured image, falling back to the unpinned image used before it was configurable
+func (cfg *Config) ImageConfig(name string) ImageConfig {
+	if image, exists := cfg.Images[name]; exists && image.Image != "" {
+		return image
+	}
+	return legacyImages[name]
+}
+
+// wordpressPhpTag matches the PHP part of a wordpress tag, e.g. "-php8.3-apache" in "6.7-php8.3-apache"
+var wordpressPhpTag = regexp.MustCompile(`(^|-)php.*$`)
+
+// ResolvedImage returns the image actually used, the wordpress tag being completed by the PHP version and variant
+func (cfg *Config) ResolvedImage(name string) ImageConfig {
+	image := cfg.ImageConfig(name)
+	if name == "wordpress" {
+		php := cfg.PhpConfig()
+		suffix := fmt.Sprintf("php%s-%s", php.Version, php.Variant)
+		// Tags saved before the php section name PHP themselves, the php section always wins
+		image.Tag = wordpressPhpTag.ReplaceAllString(image.Tag, "")
+		if image.Tag == "" || image.Tag == "latest" {
+			image.Tag = suffix
+		} else {
+			image.Tag += "-" + suffix
+		}
+	}
+	return image
+}
+
+// Image returns the reference of a resolved image, used by templates
+func (cfg *Config) Image(name string) string {
+	return cfg.ResolvedImage(name).Reference()
+}
+
+// PhpConfig returns the PHP configuration, projects created before it was configurable run PHP 8.3 on Apache
+func (cfg *Config) PhpConfig() *PhpConfig {
+	if cfg.Php == nil {
+		return NewDefaultPhpConfig()
+	}
+	return cfg.Php
+}
+
+// InstanceIdentifiers returns all instances identifiers, naturally sorted (user2 before user10)
+func (cfg *Config) InstanceIdentifiers() []string {
+	if cfg.Instances == nil {
+		return nil
+	}
+	identifiers := make([]string, 0, len(cfg.Instances.Credentials))
+	for identifier := range cfg.Instances.Credentials {
+		identifiers = append(identifiers, identifier)
+	}
+	slices.SortFunc(identifiers, compareIdentifiers)
+	return identifiers
+}
+
+// InstanceFromHost returns the instance identifier served on host, if any
+func (cfg *Config) InstanceFromHost(host string) (string, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	identifier, found := strings.CutSuffix(host, "."+cfg.BaseDomain)
+	if !found || !cfg.HasInstance(identifier) {
+		return "", false
+	}
+	return identifier, true
+}
+
+// TargetContainerName returns the container name of an instance identifier or the model
+func (cfg *Config) TargetContainerName(target string) (string, error) {
+	if target == ModelTarget {
+		return cfg.ModelContainerName(), nil
+	}
+	if !cfg.HasInstance(target) {
+		return "", fmt.Errorf("instance %s does not exist", target)
+	}
+	return cfg.InstanceContainerName(target), nil
+}
+
+// SetTargetCredentials replaces the credentials of an instance identifier or the model
+func (cfg *Config) SetTargetCredentials(target string, credentials CredentialsConfig) error {
+	if target == ModelTarget {
+		if cfg.Model == nil {
+			return fmt.Errorf("model is not configured, run 'multipress deploy' first")
+		}
+		cfg.Model.Credentials = credentials
+		return nil
+	}
+	if !cfg.HasInstance(target) {
+		return fmt.Errorf("instance %s does not exist", target)
+	}
+	cfg.Instances.Credentials[target] = credentials
+	return nil
+}
+
+// TargetCredentials returns the credentials of an instance identifier or the model
+func (cfg *Config) TargetCredentials(target string) (CredentialsConfig, error) {
+	if target == ModelTarget {
+		if cfg.Model == nil {
+			return CredentialsConfig{}, fmt.Errorf("model is not configured, run 'multipress deploy' first")
+		}
+		return cfg.Model.Credentials, nil
+	}
+	if !cfg.HasInstance(target) {
+		return CredentialsConfig{}, fmt.Errorf("instance %s does not exist", target)
+	}
+	return cfg.Instances.Credentials[target], nil
+}
+
+// ParseTags parses "key=value" pairs, as given to --tag flags
+func ParseTags(values []string) (map[string]string, error) {
+	tags := make(map[string]string, len(values))
+	for _, value := range values {
+		key, tagValue, found := strings.Cut(value, "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("invalid tag %q, expected key=value", value)
+		}
+		tags[key] = tagValue
+	}
+	return tags, nil
+}
+
+// SelectInstances returns identifiers of instances matching all selectors, see InstanceMetadata.Matches
+func (cfg *Config) SelectInstances(selectors []string) []string {
+	var identifiers []string
+	for _, identifier := range cfg.InstanceIdentifiers() {
+		if cfg.Instances.Metadata[identifier].Matches(selectors) {
+			identifiers = append(identifiers, identifier)
+		}
+	}
+	return identifiers
+}
+
+func (cfg *Config) HasInstance(identifier string) bool {
+	if cfg.Instances == nil {
+		return false
+	}
+	_, exists := cfg.Instances.Credentials[identifier]
+	return exists
+}
+
+// RedactedValue replaces secrets of redacted configurations
+const RedactedValue = "<redacted>"
+
+// Redacted returns a copy of the configuration whose passwords, keys and tokens are replaced, e.g. to be recorded with backups
+func (cfg *Config) Redacted() (*Config, error) {
 	data, err := yaml.Marshal(cfg)
 	if err != nil {
-		return fmt.Errorf("failed to marshal config to YAML: %w", err)
+		return nil, fmt.Errorf("failed to marshal config to YAML: %w", err)
 	}
-	file, err := os.Create(path)
-	if err != nil {
-		return fmt.Errorf("failed to create file at %s: %w", path, err)
+	var redacted Config
+	if err := yaml.Unmarshal(data, &redacted); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal YAML data: %w", err)
 	}
-	defer file.Close()
-	_, err = file.Write(data)
-	if err != nil {
-		return fmt.Errorf("failed to write data to file: %w", err)
+
+	redact := func(secrets ...*string) {
+		for _, secret := range secrets {
+			if *secret != "" {
+				*secret = RedactedValue
+			}
+		}
+	}
+	redactCredentials := func(credentials *CredentialsConfig) {
+		redact(&credentials.DBPassword, &credentials.Password)
 	}
 
+	if redacted.Caddy != nil {
+		for key := range redacted.Caddy.DnsEnv {
+			redacted.Caddy.DnsEnv[key] = RedactedValue
+		}
+	}
+	if redacted.MySql != nil {
+		redact(&redacted.MySql.RootPassword)
+	}
+	if redacted.Model != nil {
+		redactCredentials(&redacted.Model.Credentials)
+	}
+	if redacted.Instances != nil {
+		for identifier, credentials := range redacted.Instances.Credentials {
+			redactCredentials(&credentials)
+			redacted.Instances.Credentials[identifier] = credentials
+		}
+	}
+	if redacted.Mail != nil {
+		redact(&redacted.Mail.Relay.Password)
+	}
+	if redacted.Notify != nil {
+		redact(&redacted.Notify.Smtp.Password)
+	}
+	accesses := []*AccessConfig{redacted.PhpMyAdmin, redacted.Backups}
+	if redacted.Mail != nil {
+		accesses = append(accesses, redacted.Mail.Access)
+	}
+	for _, access := range accesses {
+		if access != nil {
+			redact(&access.Password, &access.PasswordHash)
+		}
+	}
+	if redacted.BackupEncryption != nil {
+		redact(&redacted.BackupEncryption.Passphrase, &redacted.BackupEncryption.NameKey)
+	}
+	for i := range redacted.BackupTargets {
+		redact(&redacted.BackupTargets[i].AccessKey, &redacted.BackupTargets[i].SecretKey, &redacted.BackupTargets[i].Password)
+	}
+	return &redacted, nil
+}
+
+func (cfg *Config) SaveAs(path string) error {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config to YAML: %w", err)
+	}
+	// Atomic, so an interrupted save never corrupts the only record of credentials
+	if err := utils.WriteFileAtomic(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write config to %s: %w", path, err)
+	}
 	return nil
 }
 
@@ -157,6 +906,34 @@ func LoadConfig(path string) (*Config, error) {
 	return &cfg, nil
 }
 
+// SetMetadata stores instance metadata, initializing the map of configurations created before metadata
+func (c *InstancesConfig) SetMetadata(identifier string, metadata InstanceMetadata) {
+	if c.Metadata == nil {
+		c.Metadata = make(map[string]InstanceMetadata)
+	}
+	c.Metadata[identifier] = metadata
+}
+
+// Remove forgets the instance credentials and metadata
+func (c *InstancesConfig) Remove(identifier string) {
+	delete(c.Credentials, identifier)
+	delete(c.Metadata, identifier)
+}
+
+// NextRedisDatabase returns the lowest Redis database used neither by the model nor by another instance
+func (c *InstancesConfig) NextRedisDatabase() (int, error) {
+	used := map[int]bool{0: true}
+	for _, credentials := range c.Credentials {
+		used[credentials.RedisDatabase] = true
+	}
+	for database := 1; database < redisDatabases; database++ {
+		if !used[database] {
+			return database, nil
+		}
+	}
+	return 0, fmt.Errorf("no Redis database left, the object cache supports %d instances", redisDatabases-1)
+}
+
 func (c *InstancesConfig) NextIdentifier() string {
 	const instancePrefix = "user"
 
@@ -179,3 +956,21 @@ func (c *InstancesConfig) NextIdentifier() string {
 	nextNumber := c._counter.Add(1)
 	return fmt.Sprintf("%s%d", instancePrefix, nextNumber)
 }
+
+func compareIdentifiers(a, b string) int {
+	prefixA, numberA := splitIdentifier(a)
+	prefixB, numberB := splitIdentifier(b)
+	if prefixA != prefixB || numberA == numberB {
+		return strings.Compare(a, b)
+	}
+	return cmp.Compare(numberA, numberB)
+}
+
+func splitIdentifier(identifier string) (string, int) {
+	prefix := strings.TrimRight(identifier, "0123456789")
+	number, err := strconv.Atoi(identifier[len(prefix):])
+	if err != nil {
+		return identifier, -1
+	}
+	return prefix, number
+}
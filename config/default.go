@@ -9,10 +9,75 @@ import (
 func NewDefaultConfig() *Config {
 	return &Config{
 		Project: "multipress",
+		Images:  NewDefaultImagesConfig(),
+		Php:     NewDefaultPhpConfig(),
 		Uid:     syscall.Getuid(),
 		Gid:     syscall.Getgid(),
 	}
 }
+
+// NewDefaultImagesConfig pins images of new projects, upgrade them deliberately
+func NewDefaultImagesConfig() map[string]ImageConfig {
+	return map[string]ImageConfig{
+		"caddy":         {Image: "lucaslorentz/caddy-docker-proxy", Tag: "2.9-alpine"},
+		"caddy-builder": {Image: "caddy", Tag: "2.9-builder-alpine"},
+		"caddy-base":    {Image: "alpine", Tag: "3.21"},
+		"dns":           {Image: "coredns/coredns", Tag: "1.11.3"},
+		"mysql":         DefaultEngineImage(EngineMysql),
+		"phpmyadmin":    {Image: "phpmyadmin/phpmyadmin", Tag: "5.2"},
+		"mail":          {Image: "axllent/mailpit", Tag: "v1.21"},
+		"redis":         {Image: "redis", Tag: "7.4-alpine"},
+		"wordpress":     {Image: "wordpress", Tag: "6.7"},
+		"backups":       {Image: "caddy", Tag: "2.9-alpine"},
+		"waker":         {Image: "debian", Tag: "12-slim"},
+	}
+}
+
+// DefaultEngineImage returns the pinned image of a database engine
+func DefaultEngineImage(engine string) ImageConfig {
+	switch engine {
+	case EngineMariadb:
+		return ImageConfig{Image: "mariadb", Tag: "11.4"}
+	case EnginePercona:
+		return ImageConfig{Image: "percona/percona-server", Tag: "8.4"}
+	}
+	return ImageConfig{Image: "mysql", Tag: "8.4"}
+}
+
+// legacyImages are used by projects created before images were configurable, a MySQL downgrade would corrupt data
+var legacyImages = map[string]ImageConfig{
+	"caddy":         {Image: "lucaslorentz/caddy-docker-proxy", Tag: "ci-alpine"},
+	"caddy-builder": {Image: "caddy", Tag: "2.9-builder-alpine"},
+	"caddy-base":    {Image: "alpine", Tag: "3.21"},
+	"dns":           {Image: "coredns/coredns", Tag: "1.11.3"},
+	"mysql":         {Image: "mysql", Tag: "latest"},
+	"phpmyadmin":    {Image: "phpmyadmin/phpmyadmin", Tag: "latest"},
+	"mail":          {Image: "axllent/mailpit", Tag: "latest"},
+	"redis":         {Image: "redis", Tag: "7.4-alpine"},
+	"wordpress":     {Image: "wordpress"},
+	"backups":       {Image: "peterberweiler/fileserver", Tag: "latest"},
+	"waker":         {Image: "debian", Tag: "bookworm-slim"},
+}
+
+func NewDefaultHostsConfig() *HostsConfig {
+	return &HostsConfig{
+		Ip:        "127.0.0.1",
+		DnsListen: "127.0.0.1:53",
+	}
+}
+
+// NewDefaultScheduleConfig backups nightly and checks health often, hibernation being opt-in
+func NewDefaultScheduleConfig() *ScheduleConfig {
+	return &ScheduleConfig{
+		Backup:       "0 3 * * *",
+		Prune:        "30 4 * * *",
+		Expire:       "0 * * * *",
+		Health:       "*/5 * * * *",
+		KeepBackups:  7,
+		ExpireBackup: true,
+	}
+}
+
 func NewDefaultCaddyConfig() *CaddyConfig {
 	return &CaddyConfig{
 		Resources: ResourcesConfig{
@@ -22,6 +87,22 @@ func NewDefaultCaddyConfig() *CaddyConfig {
 	}
 }
 
+// DnsProvider holds the acme_dns arguments of a github.com/caddy-dns module, and the credentials it reads
+type DnsProvider struct {
+	Name string
+	Args string
+	Env  []string
+}
+
+// DnsProviders are proposed by deploy, others can be set in configuration
+var DnsProviders = []DnsProvider{
+	{"cloudflare", "{env.CLOUDFLARE_API_TOKEN}", []string{"CLOUDFLARE_API_TOKEN"}},
+	{"route53", "", []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_REGION"}},
+	{"digitalocean", "{env.DO_AUTH_TOKEN}", []string{"DO_AUTH_TOKEN"}},
+	{"hetzner", "{env.HETZNER_API_TOKEN}", []string{"HETZNER_API_TOKEN"}},
+	{"gandi", "{env.GANDI_BEARER_TOKEN}", []string{"GANDI_BEARER_TOKEN"}},
+}
+
 func NewDefaultMysqlConfig() *MysqlConfig {
 	return &MysqlConfig{
 		Resources: ResourcesConfig{
@@ -67,3 +148,65 @@ func NewDefaultInstanceCredentialConfig(cfg *Config, identifier string) *Credent
 		Email:    fmt.Sprintf(`%s@%s.%s`, identifier, identifier, cfg.BaseDomain),
 	}
 }
+
+func NewDefaultPhpConfig() *PhpConfig {
+	return &PhpConfig{
+		Version: "8.3",
+		Variant: PhpVariantApache,
+		Ini: map[string]string{
+			"upload_max_filesize": "2048M",
+			"post_max_size":       "2048M",
+		},
+	}
+}
+
+func NewDefaultRedisConfig() *RedisConfig {
+	return &RedisConfig{
+		Resources: ResourcesConfig{
+			Memory: "320M",
+		},
+		Enabled:   true,
+		MaxMemory: "256mb",
+	}
+}
+
+func NewDefaultMailConfig(cfg *Config) *MailConfig {
+	return &MailConfig{
+		Resources: ResourcesConfig{
+			Memory: "128M",
+		},
+		Mode:   MailModeCatcher,
+		Access: NewDefaultAccessConfig(),
+		Relay: SmtpConfig{
+			Port:       587,
+			From:       "wordpress@" + cfg.BaseDomain,
+			Encryption: "starttls",
+		},
+	}
+}
+
+func NewDefaultAccessConfig() *AccessConfig {
+	return &AccessConfig{
+		Enabled:  true,
+		Username: "admin",
+		Password: utils.GenerateSecurePassword(16),
+	}
+}
+
+func NewDefaultHibernationConfig() *HibernationConfig {
+	return &HibernationConfig{
+		IdleTimeout: "30m",
+	}
+}
+
+func NewDefaultNotifyConfig(cfg *Config) *NotifyConfig {
+	return &NotifyConfig{
+		Smtp: SmtpConfig{
+			Host:       "localhost",
+			Port:       1025,
+			From:       "multipress@" + cfg.BaseDomain,
+			Encryption: "none",
+		},
+		Subject: "Your WordPress instance {{ .Identifier }} is ready",
+	}
+}
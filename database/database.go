@@ -0,0 +1,60 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/utils"
+)
+
+// Connect opens a root connection to the project MySQL, on dbName if not empty
+func Connect(cfg *config.Config, dbName string) (*sql.DB, error) {
+	mysqlIP, err := utils.GetDockerContainerIP(cfg.MysqlContainerName())
+	if err != nil {
+		return nil, err
+	}
+
+	mysqlConnector, err := mysql.NewConnector(&mysql.Config{User: "root", Passwd: cfg.MySql.RootPassword, Addr: mysqlIP, DBName: dbName})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MySQL: %w", err)
+	}
+	return sql.OpenDB(mysqlConnector), nil
+}
+
+func ExecStatements(db *sql.DB, statements []string) error {
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			return fmt.Errorf("failed to execute statement %q: %w", statement, err)
+		}
+	}
+	return nil
+}
+
+// ListTables returns tables of dbName
+func ListTables(db *sql.DB, dbName string) ([]string, error) {
+	rows, err := db.Query("SELECT TABLE_NAME FROM information_schema.TABLES WHERE TABLE_SCHEMA = ?", dbName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables of %s: %w", dbName, err)
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return nil, fmt.Errorf("failed to list tables of %s: %w", dbName, err)
+		}
+		tables = append(tables, table)
+	}
+	return tables, rows.Err()
+}
+
+// CountTables returns the number of tables of dbName
+func CountTables(db *sql.DB, dbName string) (int, error) {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM information_schema.TABLES WHERE TABLE_SCHEMA = ?", dbName).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count tables of %s: %w", dbName, err)
+	}
+	return count, nil
+}
@@ -0,0 +1,180 @@
+package database
+
+import (
+	"fmt"
+	"github.com/quix-labs/multipress/config"
+	"strings"
+)
+
+// Engines supported by Dialect, the first one being the default
+var Engines = []string{config.EngineMysql, config.EngineMariadb, config.EnginePercona}
+
+// Dialect builds commands and statements for a database engine, without side effects
+type Dialect struct {
+	Engine string
+}
+
+func NewDialect(engine string) (Dialect, error) {
+	if engine == "" {
+		engine = config.EngineMysql
+	}
+	for _, e := range Engines {
+		if e == engine {
+			return Dialect{Engine: engine}, nil
+		}
+	}
+	return Dialect{}, fmt.Errorf("unknown database engine %q, expected one of %s", engine, strings.Join(Engines, ", "))
+}
+
+// DialectOf returns the dialect of the project engine
+func DialectOf(cfg *config.Config) (Dialect, error) {
+	if cfg.MySql == nil {
+		return NewDialect("")
+	}
+	return NewDialect(cfg.MySql.Engine)
+}
+
+func (d Dialect) isMariadb() bool {
+	return d.Engine == config.EngineMariadb
+}
+
+// RootPasswordEnv is the variable initializing the root password of the image
+func (d Dialect) RootPasswordEnv() string {
+	if d.isMariadb() {
+		return "MARIADB_ROOT_PASSWORD"
+	}
+	return "MYSQL_ROOT_PASSWORD"
+}
+
+// HealthcheckCommand pings the server from inside its container
+func (d Dialect) HealthcheckCommand(rootPassword string) string {
+	admin := "mysqladmin"
+	if d.isMariadb() {
+		admin = "mariadb-admin"
+	}
+	return fmt.Sprintf(`%s ping -h 127.0.0.1 -u root --password="%s"`, admin, rootPassword)
+}
+
+// DumpCommand dumps dbName to stdout, the password being given through MYSQL_PWD
+func (d Dialect) DumpCommand(user string, dbName string) []string {
+	if d.isMariadb() {
+		return []string{"mariadb-dump", "-u", user, dbName}
+	}
+	return []string{"mysqldump", "-u", user, dbName}
+}
+
+// ClientCommand opens a client on dbName, reading statements from stdin, the password being given through MYSQL_PWD
+func (d Dialect) ClientCommand(user string, dbName string) []string {
+	if d.isMariadb() {
+		return []string{"mariadb", "-u", user, dbName}
+	}
+	return []string{"mysql", "-u", user, dbName}
+}
+
+// CreateStatements (re)creates the database and its user, granted on it only
+func (d Dialect) CreateStatements(credentials config.CredentialsConfig) []string {
+	return append(d.DropStatements(credentials),
+		fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", quoteIdentifier(credentials.DBName)),
+		fmt.Sprintf("CREATE USER IF NOT EXISTS %s %s", account(credentials.DBUser), d.identifiedBy(credentials.DBPassword)),
+		fmt.Sprintf("GRANT ALL PRIVILEGES ON %s.* TO %s", quoteIdentifier(credentials.DBName), account(credentials.DBUser)),
+		"FLUSH PRIVILEGES",
+	)
+}
+
+// EnsureStatements creates the database and its user when missing, keeping existing data
+func (d Dialect) EnsureStatements(credentials config.CredentialsConfig) []string {
+	return []string{
+		fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", quoteIdentifier(credentials.DBName)),
+		fmt.Sprintf("CREATE USER IF NOT EXISTS %s %s", account(credentials.DBUser), d.identifiedBy(credentials.DBPassword)),
+		fmt.Sprintf("ALTER USER %s %s", account(credentials.DBUser), d.identifiedBy(credentials.DBPassword)),
+		fmt.Sprintf("GRANT ALL PRIVILEGES ON %s.* TO %s", quoteIdentifier(credentials.DBName), account(credentials.DBUser)),
+		"FLUSH PRIVILEGES",
+	}
+}
+
+func (d Dialect) DropStatements(credentials config.CredentialsConfig) []string {
+	return []string{
+		fmt.Sprintf("DROP DATABASE IF EXISTS %s", quoteIdentifier(credentials.DBName)),
+		fmt.Sprintf("DROP USER IF EXISTS %s", account(credentials.DBUser)),
+	}
+}
+
+// ScratchStatements (re)creates an empty database without user, e.g. to test a dump
+func (d Dialect) ScratchStatements(dbName string) []string {
+	return []string{
+		"DROP DATABASE IF EXISTS " + quoteIdentifier(dbName),
+		"CREATE DATABASE " + quoteIdentifier(dbName),
+	}
+}
+
+// DropDatabaseStatements drops a database, keeping its user
+func (d Dialect) DropDatabaseStatements(dbName string) []string {
+	return []string{"DROP DATABASE IF EXISTS " + quoteIdentifier(dbName)}
+}
+
+// SwapTablesStatements moves current tables of dbName into previous and tables of replacement into dbName,
+// in a single RENAME TABLE applied entirely or not at all
+func (d Dialect) SwapTablesStatements(dbName string, current []string, replacement string, replacing []string, previous string) []string {
+	renames := make([]string, 0, len(current)+len(replacing))
+	for _, table := range current {
+		renames = append(renames, qualifiedTable(dbName, table)+" TO "+qualifiedTable(previous, table))
+	}
+	for _, table := range replacing {
+		renames = append(renames, qualifiedTable(replacement, table)+" TO "+qualifiedTable(dbName, table))
+	}
+	if len(renames) == 0 {
+		return nil
+	}
+	return []string{"RENAME TABLE " + strings.Join(renames, ", ")}
+}
+
+func (d Dialect) AlterPasswordStatements(user string, password string) []string {
+	return []string{
+		fmt.Sprintf("ALTER USER %s %s", account(user), d.identifiedBy(password)),
+		"FLUSH PRIVILEGES",
+	}
+}
+
+func (d Dialect) AlterRootPasswordStatements(password string) []string {
+	if d.isMariadb() {
+		// root@localhost also authenticates through unix_socket, SET PASSWORD keeps it
+		return []string{
+			fmt.Sprintf("ALTER USER IF EXISTS 'root'@'%%' %s", d.identifiedBy(password)),
+			fmt.Sprintf("SET PASSWORD FOR 'root'@'localhost' = PASSWORD(%s)", quoteString(password)),
+			"FLUSH PRIVILEGES",
+		}
+	}
+	return []string{
+		fmt.Sprintf("ALTER USER IF EXISTS 'root'@'%%' %s", d.identifiedBy(password)),
+		fmt.Sprintf("ALTER USER IF EXISTS 'root'@'localhost' %s", d.identifiedBy(password)),
+		"FLUSH PRIVILEGES",
+	}
+}
+
+// identifiedBy pins the authentication plugin, defaults differ between engines and versions
+func (d Dialect) identifiedBy(password string) string {
+	if d.isMariadb() {
+		return fmt.Sprintf("IDENTIFIED VIA mysql_native_password USING PASSWORD(%s)", quoteString(password))
+	}
+	return fmt.Sprintf("IDENTIFIED WITH caching_sha2_password BY %s", quoteString(password))
+}
+
+// quoteIdentifier quotes a database or table name, backticks being doubled
+func quoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// qualifiedTable quotes a table of database dbName
+func qualifiedTable(dbName string, table string) string {
+	return quoteIdentifier(dbName) + "." + quoteIdentifier(table)
+}
+
+// quoteString quotes a string literal such as a user name or a password
+func quoteString(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", "''").Replace(value) + "'"
+}
+
+// account is the user connecting from any host
+func account(user string) string {
+	return quoteString(user) + "@'%'"
+}
@@ -0,0 +1,251 @@
+package database
+
+import (
+	"github.com/quix-labs/multipress/config"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// hostileCredentials would break out of their quotes if not escaped
+var hostileCredentials = config.CredentialsConfig{
+	DBName:     "wp`; DROP DATABASE model; -- ",
+	DBUser:     `user3'@'%'; DROP USER root; -- \`,
+	DBPassword: `it's \' a "secret"`,
+}
+
+type tokenKind int
+
+const (
+	word tokenKind = iota
+	identifier
+	literal
+	symbol
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+}
+
+// tokenize splits a statement as MySQL reads it, unquoting identifiers and string literals
+func tokenize(t *testing.T, statement string) []token {
+	t.Helper()
+	var tokens []token
+	for i := 0; i < len(statement); {
+		switch c := statement[i]; {
+		case c == ' ':
+			i++
+		case c == '`' || c == '\'':
+			var value strings.Builder
+			for i++; ; i++ {
+				if i >= len(statement) {
+					t.Fatalf("unterminated quote in %q", statement)
+				}
+				if c == '\'' && statement[i] == '\\' && i+1 < len(statement) {
+					i++
+					value.WriteByte(statement[i])
+					continue
+				}
+				if statement[i] == c {
+					if i+1 < len(statement) && statement[i+1] == c {
+						i++
+						value.WriteByte(c)
+						continue
+					}
+					i++
+					break
+				}
+				value.WriteByte(statement[i])
+			}
+			kind := identifier
+			if c == '\'' {
+				kind = literal
+			}
+			tokens = append(tokens, token{kind, value.String()})
+		case strings.ContainsRune("@.*=(),;", rune(c)):
+			tokens = append(tokens, token{symbol, string(c)})
+			i++
+		default:
+			start := i
+			for i < len(statement) && !strings.ContainsRune(" `'@.*=(),;", rune(statement[i])) {
+				i++
+			}
+			tokens = append(tokens, token{word, statement[start:i]})
+		}
+	}
+	return tokens
+}
+
+func words(values ...string) []token {
+	tokens := make([]token, len(values))
+	for i, value := range values {
+		tokens[i] = token{word, value}
+	}
+	return tokens
+}
+
+// accountTokens are the tokens of user@'%'
+func accountTokens(user string) []token {
+	return []token{{literal, user}, {symbol, "@"}, {literal, "%"}}
+}
+
+func identifiedTokens(engine string, password string) []token {
+	if engine == config.EngineMariadb {
+		return append(words("IDENTIFIED", "VIA", "mysql_native_password", "USING", "PASSWORD"),
+			token{symbol, "("}, token{literal, password}, token{symbol, ")"})
+	}
+	return append(words("IDENTIFIED", "WITH", "caching_sha2_password", "BY"), token{literal, password})
+}
+
+func concat(parts ...[]token) []token {
+	return slices.Concat(parts...)
+}
+
+func newTestDialect(t *testing.T, engine string) Dialect {
+	t.Helper()
+	dialect, err := NewDialect(engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dialect
+}
+
+// assertTokens checks statements one by one, a statement per element of want
+func assertTokens(t *testing.T, name string, statements []string, want ...[]token) {
+	t.Helper()
+	if len(statements) != len(want) {
+		t.Fatalf("%s: got %d statements %q, want %d", name, len(statements), statements, len(want))
+	}
+	for i, statement := range statements {
+		if got := tokenize(t, statement); !slices.Equal(got, want[i]) {
+			t.Errorf("%s: statement %q read as\n got  %v\n want %v", name, statement, got, want[i])
+		}
+	}
+}
+
+func TestNewDialect(t *testing.T) {
+	if dialect := newTestDialect(t, ""); dialect.Engine != config.EngineMysql {
+		t.Errorf("default engine is %s, want %s", dialect.Engine, config.EngineMysql)
+	}
+	if _, err := NewDialect("postgres"); err == nil {
+		t.Error("unknown engine accepted")
+	}
+}
+
+func TestCreateStatementsGrantOnlyTheDatabase(t *testing.T) {
+	for _, engine := range Engines {
+		t.Run(engine, func(t *testing.T) {
+			credentials := hostileCredentials
+			drop := [][]token{
+				concat(words("DROP", "DATABASE", "IF", "EXISTS"), []token{{identifier, credentials.DBName}}),
+				concat(words("DROP", "USER", "IF", "EXISTS"), accountTokens(credentials.DBUser)),
+			}
+			assertTokens(t, "DropStatements", newTestDialect(t, engine).DropStatements(credentials), drop...)
+
+			assertTokens(t, "CreateStatements", newTestDialect(t, engine).CreateStatements(credentials), append(drop,
+				concat(words("CREATE", "DATABASE", "IF", "NOT", "EXISTS"), []token{{identifier, credentials.DBName}}),
+				concat(words("CREATE", "USER", "IF", "NOT", "EXISTS"), accountTokens(credentials.DBUser), identifiedTokens(engine, credentials.DBPassword)),
+				concat(words("GRANT", "ALL", "PRIVILEGES", "ON"), []token{{identifier, credentials.DBName}, {symbol, "."}, {symbol, "*"}},
+					words("TO"), accountTokens(credentials.DBUser)),
+				words("FLUSH", "PRIVILEGES"),
+			)...)
+		})
+	}
+}
+
+func TestEnsureStatementsKeepData(t *testing.T) {
+	for _, engine := range Engines {
+		t.Run(engine, func(t *testing.T) {
+			credentials := hostileCredentials
+			assertTokens(t, "EnsureStatements", newTestDialect(t, engine).EnsureStatements(credentials),
+				concat(words("CREATE", "DATABASE", "IF", "NOT", "EXISTS"), []token{{identifier, credentials.DBName}}),
+				concat(words("CREATE", "USER", "IF", "NOT", "EXISTS"), accountTokens(credentials.DBUser), identifiedTokens(engine, credentials.DBPassword)),
+				concat(words("ALTER", "USER"), accountTokens(credentials.DBUser), identifiedTokens(engine, credentials.DBPassword)),
+				concat(words("GRANT", "ALL", "PRIVILEGES", "ON"), []token{{identifier, credentials.DBName}, {symbol, "."}, {symbol, "*"}},
+					words("TO"), accountTokens(credentials.DBUser)),
+				words("FLUSH", "PRIVILEGES"),
+			)
+		})
+	}
+}
+
+func TestAlterPasswordStatements(t *testing.T) {
+	for _, engine := range Engines {
+		t.Run(engine, func(t *testing.T) {
+			password := hostileCredentials.DBPassword
+			assertTokens(t, "AlterPasswordStatements", newTestDialect(t, engine).AlterPasswordStatements(hostileCredentials.DBUser, password),
+				concat(words("ALTER", "USER"), accountTokens(hostileCredentials.DBUser), identifiedTokens(engine, password)),
+				words("FLUSH", "PRIVILEGES"),
+			)
+
+			localhost := concat(words("ALTER", "USER", "IF", "EXISTS"), []token{{literal, "root"}, {symbol, "@"}, {literal, "localhost"}}, identifiedTokens(engine, password))
+			if engine == config.EngineMariadb {
+				// root@localhost keeps its unix_socket authentication
+				localhost = concat(words("SET", "PASSWORD", "FOR"), []token{{literal, "root"}, {symbol, "@"}, {literal, "localhost"}, {symbol, "="}},
+					words("PASSWORD"), []token{{symbol, "("}, {literal, password}, {symbol, ")"}})
+			}
+			assertTokens(t, "AlterRootPasswordStatements", newTestDialect(t, engine).AlterRootPasswordStatements(password),
+				concat(words("ALTER", "USER", "IF", "EXISTS"), accountTokens("root"), identifiedTokens(engine, password)),
+				localhost,
+				words("FLUSH", "PRIVILEGES"),
+			)
+		})
+	}
+}
+
+func TestScratchStatements(t *testing.T) {
+	dbName := hostileCredentials.DBName + "_restore"
+	assertTokens(t, "ScratchStatements", newTestDialect(t, "").ScratchStatements(dbName),
+		concat(words("DROP", "DATABASE", "IF", "EXISTS"), []token{{identifier, dbName}}),
+		concat(words("CREATE", "DATABASE"), []token{{identifier, dbName}}),
+	)
+}
+
+func TestSwapTablesStatements(t *testing.T) {
+	dbName := hostileCredentials.DBName
+	replacement, previous := dbName+"_restore", dbName+"_previous"
+	table := func(dbName string, table string) []token {
+		return []token{{identifier, dbName}, {symbol, "."}, {identifier, table}}
+	}
+
+	// A single statement, MySQL renaming all tables or none
+	assertTokens(t, "SwapTablesStatements", newTestDialect(t, "").SwapTablesStatements(dbName, []string{"wp_posts", "wp`old"}, replacement, []string{"wp_posts"}, previous),
+		concat(words("RENAME", "TABLE"),
+			table(dbName, "wp_posts"), words("TO"), table(previous, "wp_posts"), []token{{symbol, ","}},
+			table(dbName, "wp`old"), words("TO"), table(previous, "wp`old"), []token{{symbol, ","}},
+			table(replacement, "wp_posts"), words("TO"), table(dbName, "wp_posts"),
+		),
+	)
+
+	if statements := newTestDialect(t, "").SwapTablesStatements(dbName, nil, replacement, nil, previous); len(statements) != 0 {
+		t.Errorf("SwapTablesStatements without tables returned %q", statements)
+	}
+}
+
+func TestDialectCommands(t *testing.T) {
+	for _, engine := range Engines {
+		t.Run(engine, func(t *testing.T) {
+			dialect := newTestDialect(t, engine)
+
+			binaries := map[string]string{"dump": "mysqldump", "client": "mysql", "admin": "mysqladmin", "env": "MYSQL_ROOT_PASSWORD"}
+			if engine == config.EngineMariadb {
+				binaries = map[string]string{"dump": "mariadb-dump", "client": "mariadb", "admin": "mariadb-admin", "env": "MARIADB_ROOT_PASSWORD"}
+			}
+
+			// Database names are passed as single arguments, never through a shell
+			if got := dialect.DumpCommand("root", hostileCredentials.DBName); got[0] != binaries["dump"] || got[len(got)-1] != hostileCredentials.DBName {
+				t.Errorf("DumpCommand: got %q", got)
+			}
+			if got := dialect.ClientCommand("root", hostileCredentials.DBName); got[0] != binaries["client"] || got[len(got)-1] != hostileCredentials.DBName {
+				t.Errorf("ClientCommand: got %q", got)
+			}
+			if got := dialect.HealthcheckCommand("root"); !strings.HasPrefix(got, binaries["admin"]+" ping ") {
+				t.Errorf("HealthcheckCommand: got %q", got)
+			}
+			if got := dialect.RootPasswordEnv(); got != binaries["env"] {
+				t.Errorf("RootPasswordEnv: got %q, want %q", got, binaries["env"])
+			}
+		})
+	}
+}
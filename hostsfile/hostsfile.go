@@ -0,0 +1,136 @@
+package hostsfile
+
+import (
+	"errors"
+	"fmt"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/utils"
+	"io/fs"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// DefaultPath is the hosts file of Linux and macOS
+const DefaultPath = "/etc/hosts"
+
+func beginMarker(project string) string {
+	return "# BEGIN multipress " + project
+}
+
+func endMarker(project string) string {
+	return "# END multipress " + project
+}
+
+// Hostnames returns hostnames served by Caddy for the project, instances included
+func Hostnames(cfg *config.Config) []string {
+	urls := []string{cfg.ModelUrl()}
+	if cfg.PhpMyAdmin == nil || cfg.PhpMyAdmin.Enabled {
+		urls = append(urls, cfg.PhpMyAdminUrl())
+	}
+	if cfg.Backups != nil && cfg.Backups.Enabled {
+		urls = append(urls, cfg.BackupsUrl())
+	}
+	if cfg.Mail != nil && cfg.Mail.UiEnabled() {
+		urls = append(urls, cfg.MailUrl())
+	}
+	for _, identifier := range cfg.InstanceIdentifiers() {
+		urls = append(urls, cfg.InstanceUrl(identifier))
+	}
+
+	hostnames := make([]string, 0, len(urls))
+	for _, rawUrl := range urls {
+		if u, err := url.Parse(rawUrl); err == nil {
+			hostnames = append(hostnames, u.Hostname())
+		}
+	}
+	return hostnames
+}
+
+// Block returns the managed block of the project, one hostname per line
+func Block(cfg *config.Config, ip string) string {
+	var b strings.Builder
+	b.WriteString(beginMarker(cfg.Project) + "\n")
+	for _, hostname := range Hostnames(cfg) {
+		b.WriteString(ip + " " + hostname + "\n")
+	}
+	b.WriteString(endMarker(cfg.Project) + "\n")
+	return b.String()
+}
+
+// Write adds or replaces the managed block of the project in the hosts file at path
+func Write(cfg *config.Config, path string, ip string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	updated, _ := strip(string(content), cfg.Project)
+	if updated != "" && !strings.HasSuffix(updated, "\n") {
+		updated += "\n"
+	}
+	return write(path, updated+Block(cfg, ip))
+}
+
+// Remove deletes the managed block of project from the hosts file at path
+func Remove(project string, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	updated, found := strip(string(content), project)
+	if !found {
+		return utils.SkippedError{Msg: "no block in " + path}
+	}
+	return write(path, updated)
+}
+
+// Sync rewrites the block after instances changed, when the project manages one.
+// Without permission on the hosts file, it is skipped with the command to run as root instead of failing.
+func Sync(cfg *config.Config) error {
+	if cfg.Hosts == nil || cfg.Hosts.File == "" {
+		return utils.SkippedError{Msg: "hosts file not managed"}
+	}
+	err := Write(cfg, cfg.Hosts.File, cfg.Hosts.Ip)
+	if errors.Is(err, fs.ErrPermission) {
+		command := "sudo multipress hosts write"
+		if cfg.Hosts.File != DefaultPath {
+			command += " --file " + cfg.Hosts.File
+		}
+		return utils.SkippedError{Msg: fmt.Sprintf("no permission on %s, run '%s'", cfg.Hosts.File, command)}
+	}
+	return err
+}
+
+// strip returns content without the block of project, reporting whether it was found
+func strip(content string, project string) (string, bool) {
+	begin, end := beginMarker(project), endMarker(project)
+
+	var kept []string
+	inBlock, found := false, false
+	for _, line := range strings.SplitAfter(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == begin:
+			inBlock, found = true, true
+		case inBlock && trimmed == end:
+			inBlock = false
+		case !inBlock:
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, ""), found
+}
+
+// write truncates instead of renaming, the hosts file of containers being a bind mount
+func write(path string, content string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(content), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write %s, run as root: %w", path, err)
+	}
+	return nil
+}
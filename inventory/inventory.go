@@ -0,0 +1,237 @@
+package inventory
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/utils"
+	"gopkg.in/yaml.v3"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats supported by Write
+var Formats = []string{"table", "json", "yaml", "csv", "dotenv"}
+
+// Record is the exported view of an instance, secrets are empty unless requested
+type Record struct {
+	Identifier string   `json:"identifier" yaml:"identifier"`
+	URL        string   `json:"url" yaml:"url"`
+	Username   string   `json:"username" yaml:"username"`
+	Password   string   `json:"password,omitempty" yaml:"password,omitempty"`
+	Email      string   `json:"email" yaml:"email"`
+	DBName     string   `json:"db_name" yaml:"db-name"`
+	DBUser     string   `json:"db_user" yaml:"db-user"`
+	DBPassword string   `json:"db_password,omitempty" yaml:"db-password,omitempty"`
+	Owner      string   `json:"owner,omitempty" yaml:"owner,omitempty"`
+	OwnerEmail string   `json:"owner_email,omitempty" yaml:"owner-email,omitempty"`
+	Notes      string   `json:"notes,omitempty" yaml:"notes,omitempty"`
+	Tags       []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	CreatedAt  string   `json:"created_at,omitempty" yaml:"created-at,omitempty"`
+	ExpiresAt  string   `json:"expires_at,omitempty" yaml:"expires-at,omitempty"`
+}
+
+type field struct {
+	Key    string
+	Header string
+	Secret bool
+	Value  func(r Record) string
+}
+
+var fields = []field{
+	{"IDENTIFIER", "Instance", false, func(r Record) string { return r.Identifier }},
+	{"URL", "URL", false, func(r Record) string { return r.URL }},
+	{"USERNAME", "Username", false, func(r Record) string { return r.Username }},
+	{"PASSWORD", "Password", true, func(r Record) string { return r.Password }},
+	{"EMAIL", "Email", false, func(r Record) string { return r.Email }},
+	{"DB_NAME", "DB Database", false, func(r Record) string { return r.DBName }},
+	{"DB_USER", "DB Username", false, func(r Record) string { return r.DBUser }},
+	{"DB_PASSWORD", "DB Password", true, func(r Record) string { return r.DBPassword }},
+	{"OWNER", "Owner", false, func(r Record) string { return r.Owner }},
+	{"OWNER_EMAIL", "Owner email", false, func(r Record) string { return r.OwnerEmail }},
+	{"NOTES", "Notes", false, func(r Record) string { return r.Notes }},
+	{"TAGS", "Tags", false, func(r Record) string { return strings.Join(r.Tags, ",") }},
+	{"CREATED_AT", "Created at", false, func(r Record) string { return r.CreatedAt }},
+	{"EXPIRES_AT", "Expires at", false, func(r Record) string { return r.ExpiresAt }},
+}
+
+func Records(cfg *config.Config, identifiers []string, withSecrets bool) []Record {
+	records := make([]Record, 0, len(identifiers))
+	for _, identifier := range identifiers {
+		credentials := cfg.Instances.Credentials[identifier]
+		metadata := cfg.Instances.Metadata[identifier]
+
+		record := Record{
+			Identifier: identifier,
+			URL:        cfg.InstanceUrl(identifier),
+			Username:   credentials.Username,
+			Email:      credentials.Email,
+			DBName:     credentials.DBName,
+			DBUser:     credentials.DBUser,
+			Owner:      metadata.Owner,
+			OwnerEmail: metadata.OwnerEmail,
+			Notes:      metadata.Notes,
+			Tags:       metadata.TagList(),
+			CreatedAt:  formatTime(metadata.CreatedAt),
+			ExpiresAt:  formatTime(metadata.ExpiresAt),
+		}
+		if withSecrets {
+			record.Password = credentials.Password
+			record.DBPassword = credentials.DBPassword
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func Write(w io.Writer, format string, records []Record, withSecrets bool) error {
+	switch format {
+	case "table":
+		return writeTable(w, records, visibleFields(withSecrets))
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	case "yaml":
+		encoder := yaml.NewEncoder(w)
+		defer encoder.Close()
+		return encoder.Encode(records)
+	case "csv":
+		return writeCsv(w, records, visibleFields(withSecrets))
+	case "dotenv":
+		return writeDotenv(w, records, visibleFields(withSecrets))
+	}
+	return fmt.Errorf("unknown format %q, expected one of %s", format, strings.Join(Formats, ", "))
+}
+
+// WriteDetails writes a single record as a vertical key/value table
+func WriteDetails(w io.Writer, record Record, withSecrets bool) {
+	t := table.NewWriter()
+	t.SetStyle(table.StyleLight)
+	t.SetOutputMirror(w)
+	for _, f := range visibleFields(withSecrets) {
+		t.AppendRow(table.Row{f.Header, f.Value(record)})
+	}
+	t.Render()
+}
+
+func visibleFields(withSecrets bool) []field {
+	visible := make([]field, 0, len(fields))
+	for _, f := range fields {
+		if withSecrets || !f.Secret {
+			visible = append(visible, f)
+		}
+	}
+	return visible
+}
+
+func writeTable(w io.Writer, records []Record, fields []field) error {
+	t := table.NewWriter()
+	t.SetStyle(table.StyleLight)
+	t.SetOutputMirror(w)
+
+	header := table.Row{}
+	for _, f := range fields {
+		header = append(header, f.Header)
+	}
+	t.AppendHeader(header)
+
+	for _, record := range records {
+		row := table.Row{}
+		for _, f := range fields {
+			row = append(row, f.Value(record))
+		}
+		t.AppendRow(row)
+	}
+	t.Render()
+	return nil
+}
+
+func writeCsv(w io.Writer, records []Record, fields []field) error {
+	writer := csv.NewWriter(w)
+
+	header := make([]string, 0, len(fields))
+	for _, f := range fields {
+		header = append(header, f.Header)
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		row := make([]string, 0, len(fields))
+		for _, f := range fields {
+			row = append(row, f.Value(record))
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+var envUnsafeChars = regexp.MustCompile(`[^A-Z0-9_]`)
+
+// writeDotenv writes one IDENTIFIER_KEY="value" variable per field, records separated by a blank line
+func writeDotenv(w io.Writer, records []Record, fields []field) error {
+	for i, record := range records {
+		if i > 0 {
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+		prefix := envUnsafeChars.ReplaceAllString(strings.ToUpper(record.Identifier), "_")
+		for _, f := range fields {
+			if f.Key == "IDENTIFIER" {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "%s_%s=%s\n", prefix, f.Key, strconv.Quote(f.Value(record))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WriteCredentialsCsv regenerates the credentials CSV from the configuration
+func WriteCredentialsCsv(cfg *config.Config) error {
+	buf := new(bytes.Buffer)
+	writer := csv.NewWriter(buf)
+	if err := writer.Write([]string{"URL", "Username", "Password", "DB Username", "DB Password", "DB Database"}); err != nil {
+		return err
+	}
+	for _, identifier := range cfg.InstanceIdentifiers() {
+		credentials := cfg.Instances.Credentials[identifier]
+		if err := writer.Write([]string{
+			cfg.InstanceUrl(identifier),
+			credentials.Username,
+			credentials.Password,
+			credentials.DBUser,
+			credentials.DBPassword,
+			credentials.DBName,
+		}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to write CSV: %w", err)
+	}
+
+	return utils.WriteFileAtomic(cfg.CredentialsCsvPath(), buf.Bytes(), 0600)
+}
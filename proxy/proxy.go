@@ -0,0 +1,346 @@
+package proxy
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/utils"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Ports are published by Caddy, project or shared
+var Ports = []uint16{80, 443}
+
+const (
+	sharedComposeName  = "multipress-proxy"
+	registryFile       = "projects.yaml"
+	composeFile        = "compose.caddy.yaml"
+	sharedDockerfile   = "caddy.Dockerfile"
+	defaultRegistryDir = "/var/lib/multipress/proxy"
+)
+
+// Dir returns the host directory of the shared proxy, MULTIPRESS_PROXY_DIR when set
+func Dir() string {
+	if dir := os.Getenv("MULTIPRESS_PROXY_DIR"); dir != "" {
+		return dir
+	}
+	return defaultRegistryDir
+}
+
+func ComposePath() string {
+	return filepath.Join(Dir(), composeFile)
+}
+
+// Project is a multipress project served by a Caddy, with absolute paths as the shared proxy lives elsewhere
+type Project struct {
+	Name       string `yaml:"name"`
+	Directory  string `yaml:"directory"`
+	BaseDomain string `yaml:"base-domain"`
+	Network    string `yaml:"network"`
+	VolumePath string `yaml:"volume-path"`
+	Image      string `yaml:"image"`
+	// BuilderImage and BaseImage rebuild Caddy with the DNS provider module
+	BuilderImage string             `yaml:"builder-image,omitempty"`
+	BaseImage    string             `yaml:"base-image,omitempty"`
+	Caddy        config.CaddyConfig `yaml:"caddy"`
+}
+
+func (p Project) CaddyVolumesPath() string {
+	return config.CaddyVolumesPath(p.Name)
+}
+
+func (p Project) CertPath() string {
+	return config.CaddyCertPath(p.Name)
+}
+
+func (p Project) KeyPath() string {
+	return config.CaddyKeyPath(p.Name)
+}
+
+// ProjectOf describes the project of cfg, commands being run from its directory
+func ProjectOf(cfg *config.Config) (Project, error) {
+	directory, err := os.Getwd()
+	if err != nil {
+		return Project{}, err
+	}
+	volumePath, err := filepath.Abs(cfg.VolumePath())
+	if err != nil {
+		return Project{}, err
+	}
+
+	project := Project{
+		Name:         cfg.Project,
+		Directory:    directory,
+		BaseDomain:   cfg.BaseDomain,
+		Network:      cfg.NetworkName(),
+		VolumePath:   volumePath,
+		Image:        cfg.Image("caddy"),
+		BuilderImage: cfg.Image("caddy-builder"),
+		BaseImage:    cfg.Image("caddy-base"),
+		Caddy:        *cfg.Caddy,
+	}
+	if project.Caddy.AcmeCARoot != "" {
+		if project.Caddy.AcmeCARoot, err = filepath.Abs(project.Caddy.AcmeCARoot); err != nil {
+			return Project{}, err
+		}
+	}
+	return project, nil
+}
+
+// Registry lists projects served by the shared proxy
+type Registry struct {
+	Projects []Project `yaml:"projects"`
+}
+
+// LoadRegistry returns an empty registry when the shared proxy was never used
+func LoadRegistry() (*Registry, error) {
+	data, err := os.ReadFile(filepath.Join(Dir(), registryFile))
+	if os.IsNotExist(err) {
+		return &Registry{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read proxy registry: %w", err)
+	}
+
+	var registry Registry
+	if err := yaml.Unmarshal(data, &registry); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal proxy registry: %w", err)
+	}
+	return &registry, nil
+}
+
+func (r *Registry) Save() error {
+	if err := utils.CreateDirectoryIfNotExists(Dir()); err != nil {
+		return fmt.Errorf("failed to create %s, run as root or set MULTIPRESS_PROXY_DIR: %w", Dir(), err)
+	}
+	data, err := yaml.Marshal(r)
+	if err != nil {
+		return err
+	}
+	// Holds DNS provider credentials of projects
+	return utils.WriteFileAtomic(filepath.Join(Dir(), registryFile), data, 0600)
+}
+
+// Set adds or replaces project by name
+func (r *Registry) Set(project Project) {
+	index := slices.IndexFunc(r.Projects, func(p Project) bool { return p.Name == project.Name })
+	if index < 0 {
+		r.Projects = append(r.Projects, project)
+		return
+	}
+	r.Projects[index] = project
+}
+
+// Remove reports whether the project was registered
+func (r *Registry) Remove(name string) bool {
+	length := len(r.Projects)
+	r.Projects = slices.DeleteFunc(r.Projects, func(p Project) bool { return p.Name == name })
+	return len(r.Projects) != length
+}
+
+// Register adds the project to the shared proxy, then applies it
+func Register(cfg *config.Config) error {
+	project, err := ProjectOf(cfg)
+	if err != nil {
+		return err
+	}
+	registry, err := LoadRegistry()
+	if err != nil {
+		return err
+	}
+
+	registry.Set(project)
+	if err := registry.Save(); err != nil {
+		return err
+	}
+	return apply(registry)
+}
+
+// Unregister removes the project from the shared proxy, stopping it with the last project
+func Unregister(project string) error {
+	registry, err := LoadRegistry()
+	if err != nil {
+		return err
+	}
+	if !registry.Remove(project) {
+		return utils.SkippedError{Msg: "not registered"}
+	}
+	if err := registry.Save(); err != nil {
+		return err
+	}
+
+	if len(registry.Projects) > 0 {
+		return apply(registry)
+	}
+	if utils.FileExists(ComposePath()) {
+		if _, err := utils.DownComposeFile(ComposePath()); err != nil {
+			return err
+		}
+	}
+	return errors.Join(utils.RemoveFile(ComposePath()), removeIfExists(filepath.Join(Dir(), sharedDockerfile)))
+}
+
+// apply recreates the shared proxy on networks and volumes of all registered projects
+func apply(registry *Registry) error {
+	// A missing network of any project would prevent the proxy to start
+	for _, project := range registry.Projects {
+		if err := utils.CreateDockerNetworkIfNotExists(project.Network); err != nil && !errors.As(err, &utils.SkippedError{}) {
+			return err
+		}
+	}
+
+	data := composeData{
+		Name:           sharedComposeName,
+		ContainerName:  config.SharedCaddyContainerName,
+		DockerfilePath: sharedDockerfile,
+		Projects:       registry.Projects,
+		Global:         globalProject(registry.Projects),
+	}
+	return deploy(data, ComposePath())
+}
+
+// globalProject provides global options of the shared proxy: the most recently registered ACME project, or the last one
+func globalProject(projects []Project) *Project {
+	for i := len(projects) - 1; i >= 0; i-- {
+		if projects[i].Caddy.IsAcme() {
+			return &projects[i]
+		}
+	}
+	return &projects[len(projects)-1]
+}
+
+// DeployProject runs the Caddy of a project not using the shared proxy
+func DeployProject(cfg *config.Config) error {
+	project, err := ProjectOf(cfg)
+	if err != nil {
+		return err
+	}
+	// Relative to the compose file, as generated before shared proxies
+	project.VolumePath = cfg.VolumePath()
+
+	data := composeData{
+		Name:           cfg.Project + "-caddy",
+		ContainerName:  cfg.CaddyContainerName(),
+		DockerfilePath: cfg.CaddyDockerfilePath(),
+		Projects:       []Project{project},
+		Global:         &project,
+	}
+	return deploy(data, cfg.CaddyComposePath())
+}
+
+// RemoveProject stops and removes the Caddy of a project, freeing ports for the shared proxy
+func RemoveProject(cfg *config.Config) error {
+	if !utils.FileExists(cfg.CaddyComposePath()) {
+		return nil
+	}
+	if _, err := utils.DownComposeFile(cfg.CaddyComposePath()); err != nil {
+		return err
+	}
+	return errors.Join(utils.RemoveFile(cfg.CaddyComposePath()), removeIfExists(cfg.CaddyDockerfilePath()))
+}
+
+func removeIfExists(path string) error {
+	if !utils.FileExists(path) {
+		return nil
+	}
+	return utils.RemoveFile(path)
+}
+
+// PortConflicts describes other containers or host processes using Ports, containerName being the expected Caddy
+func PortConflicts(containerName string) ([]string, error) {
+	publishers, err := utils.DockerPortPublishers()
+	if err != nil {
+		return nil, err
+	}
+	listening, err := utils.ListeningTCPPorts()
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []string
+	for _, port := range Ports {
+		others := slices.DeleteFunc(slices.Clone(publishers[port]), func(name string) bool { return name == containerName })
+		switch {
+		case len(others) > 0:
+			conflicts = append(conflicts, fmt.Sprintf("port %d is published by container %s", port, strings.Join(others, ", ")))
+		case len(publishers[port]) == 0 && listening[port]:
+			conflicts = append(conflicts, fmt.Sprintf("port %d is used by a host process (nginx, apache...)", port))
+		}
+	}
+	return conflicts, nil
+}
+
+//go:embed tmpl/caddy.yaml.tmpl
+var composeTmpl string
+
+//go:embed tmpl/caddy.Dockerfile.tmpl
+var dockerfileTmpl string
+
+type composeData struct {
+	Name           string
+	ContainerName  string
+	DockerfilePath string
+	Projects       []Project
+	// Global provides the image, resources and global options
+	Global *Project
+}
+
+func (d composeData) Image() string {
+	return d.Global.Image
+}
+
+func (d composeData) IngressNetworks() string {
+	networks := make([]string, len(d.Projects))
+	for i, project := range d.Projects {
+		networks[i] = project.Network
+	}
+	return strings.Join(networks, ",")
+}
+
+// WildcardDomains returns base domains covered by a wildcard certificate, keyed by site label index.
+// Wildcards need the DNS-01 challenge, only projects with the DNS provider of the global acme_dns get one.
+func (d composeData) WildcardDomains() map[int]string {
+	global := d.Global.Caddy
+	domains := make(map[int]string)
+	if global.DnsProvider == "" {
+		return domains
+	}
+	for _, project := range d.Projects {
+		if project.Caddy.IsAcme() && project.Caddy.DnsProvider == global.DnsProvider && project.Caddy.DnsProviderArgs == global.DnsProviderArgs {
+			domains[len(domains)+1] = project.BaseDomain
+		}
+	}
+	return domains
+}
+
+func deploy(data composeData, composePath string) error {
+	conflicts, err := PortConflicts(data.ContainerName)
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("%s cannot start: %s", data.ContainerName, strings.Join(conflicts, "; "))
+	}
+
+	if err := utils.ParseTemplateToFile(composeTmpl, data, composePath); err != nil {
+		return err
+	}
+
+	if data.Global.Caddy.DnsProvider != "" {
+		dockerfilePath := filepath.Join(filepath.Dir(composePath), data.DockerfilePath)
+		if err := utils.ParseTemplateToFile(dockerfileTmpl, data, dockerfilePath); err != nil {
+			return err
+		}
+		if _, err := utils.BuildComposeFile(composePath); err != nil {
+			return err
+		}
+	}
+
+	_, err = utils.UpComposeFile(composePath)
+	return err
+}
@@ -0,0 +1,190 @@
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/utils"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/sync/errgroup"
+	"slices"
+)
+
+// Step is a labelled action ready to be wrapped into utils.Spin
+type Step struct {
+	Label string
+	Run   func() error
+}
+
+// Selection describes which parts of the project a command acts on
+type Selection struct {
+	Infra       bool
+	Identifiers []string
+	Parallel    int
+}
+
+type composeStack struct {
+	Name string
+	Path string
+}
+
+// SelectFlag filters instances by tags, use SelectedInstances to read it
+func SelectFlag() cli.Flag {
+	return &cli.StringSliceFlag{
+		Name:  "select",
+		Usage: "Only act on instances matching all tag selectors (tag=value, or tag for presence)",
+	}
+}
+
+// SelectedInstances returns identifiers given as arguments (all instances if none), filtered by SelectFlag
+func SelectedInstances(c *cli.Context, cfg *config.Config, identifiers []string) ([]string, error) {
+	for _, identifier := range identifiers {
+		if !cfg.HasInstance(identifier) {
+			return nil, fmt.Errorf("instance %s does not exist", identifier)
+		}
+	}
+
+	selected := cfg.SelectInstances(c.StringSlice("select"))
+	if len(identifiers) > 0 {
+		selected = slices.DeleteFunc(selected, func(identifier string) bool {
+			return !slices.Contains(identifiers, identifier)
+		})
+	}
+	return selected, nil
+}
+
+// Flags returns the selection flags shared by commands acting on project resources
+func Flags() []cli.Flag {
+	return []cli.Flag{
+		SelectFlag(),
+		&cli.BoolFlag{
+			Name:  "instances-only",
+			Usage: "Only act on instances, ignoring infrastructure (caddy, dns, mysql, mail, redis, model, backups, waker)",
+		},
+		&cli.BoolFlag{
+			Name:  "infra-only",
+			Usage: "Only act on infrastructure (caddy, dns, mysql, mail, redis, model, backups, waker), ignoring instances",
+		},
+		&cli.IntFlag{
+			Name:    "parallel",
+			Aliases: []string{"p"},
+			Usage:   "Maximum number of instances processed simultaneously",
+			Value:   10,
+		},
+	}
+}
+
+// ParseSelection builds the selection from positional identifiers and Flags
+func ParseSelection(c *cli.Context, cfg *config.Config) (Selection, error) {
+	instancesOnly, infraOnly := c.Bool("instances-only"), c.Bool("infra-only")
+	identifiers := c.Args().Slice()
+	selecting := len(identifiers) > 0 || c.IsSet("select")
+
+	if instancesOnly && infraOnly {
+		return Selection{}, errors.New("--instances-only and --infra-only are mutually exclusive")
+	}
+	if selecting && (instancesOnly || infraOnly) {
+		return Selection{}, errors.New("identifiers and --select cannot be combined with --instances-only or --infra-only")
+	}
+
+	selection := Selection{Infra: !selecting && !instancesOnly, Parallel: c.Int("parallel")}
+	if !infraOnly {
+		var err error
+		if selection.Identifiers, err = SelectedInstances(c, cfg, identifiers); err != nil {
+			return Selection{}, err
+		}
+	}
+	return selection, nil
+}
+
+// infrastructure returns compose stacks in start order, each one waiting for the previous to be healthy
+func infrastructure(cfg *config.Config) []composeStack {
+	return []composeStack{
+		{"caddy", cfg.CaddyComposePath()},
+		{"dns", cfg.DnsComposePath()},
+		{"mysql", cfg.MysqlComposePath()},
+		{"mail", cfg.MailComposePath()},
+		{"redis", cfg.RedisComposePath()},
+		{"model", cfg.ModelComposePath()},
+		{"backups", cfg.BackupsComposePath()},
+		{"waker", cfg.WakerComposePath()},
+	}
+}
+
+// UpSteps starts network → caddy → dns → mysql → mail → redis → model → backups → waker sequentially, then instances in parallel
+func UpSteps(cfg *config.Config, selection Selection) []Step {
+	var steps []Step
+
+	if selection.Infra {
+		steps = append(steps, Step{"Creating Docker network", func() error {
+			return utils.CreateDockerNetworkIfNotExists(cfg.NetworkName())
+		}})
+		for _, s := range infrastructure(cfg) {
+			steps = append(steps, Step{"Starting " + s.Name, func() error {
+				return upComposeFileIfExists(s.Path)
+			}})
+		}
+	}
+
+	if len(selection.Identifiers) > 0 {
+		steps = append(steps, Step{fmt.Sprintf("Starting %d instance(s)", len(selection.Identifiers)), func() error {
+			return forEachInstance(cfg, selection, upComposeFileIfExists)
+		}})
+	}
+	return steps
+}
+
+// DownSteps stops instances in parallel first, then infrastructure in reverse start order
+func DownSteps(cfg *config.Config, selection Selection) []Step {
+	var steps []Step
+
+	if len(selection.Identifiers) > 0 {
+		steps = append(steps, Step{fmt.Sprintf("Stopping %d instance(s)", len(selection.Identifiers)), func() error {
+			return forEachInstance(cfg, selection, downComposeFileIfExists)
+		}})
+	}
+
+	if selection.Infra {
+		stacks := infrastructure(cfg)
+		for i := len(stacks) - 1; i >= 0; i-- {
+			s := stacks[i]
+			steps = append(steps, Step{"Stopping " + s.Name, func() error {
+				return downComposeFileIfExists(s.Path)
+			}})
+		}
+	}
+	return steps
+}
+
+func forEachInstance(cfg *config.Config, selection Selection, run func(composePath string) error) error {
+	var g errgroup.Group
+	g.SetLimit(max(selection.Parallel, 1))
+
+	errs := make([]error, len(selection.Identifiers))
+	for i, identifier := range selection.Identifiers {
+		g.Go(func() error {
+			if err := run(cfg.InstanceComposePath(identifier)); err != nil && !errors.As(err, &utils.SkippedError{}) {
+				errs[i] = fmt.Errorf("%s: %w", identifier, err)
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+	return errors.Join(errs...)
+}
+
+func upComposeFileIfExists(composePath string) error {
+	if !utils.FileExists(composePath) {
+		return utils.SkippedError{Msg: "not deployed"}
+	}
+	_, err := utils.UpComposeFile(composePath)
+	return err
+}
+
+func downComposeFileIfExists(composePath string) error {
+	if !utils.FileExists(composePath) {
+		return utils.SkippedError{Msg: "not deployed"}
+	}
+	_, err := utils.DownComposeFile(composePath)
+	return err
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/quix-labs/multipress/config"
+	"github.com/quix-labs/multipress/utils"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// local copies backups to another directory, e.g. a mounted disk or network share
+type local struct {
+	root string
+}
+
+func newLocal(cfg config.BackupTargetConfig) (*local, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("path of local backup target " + cfg.Name + " is required")
+	}
+	return &local{root: cfg.Path}, nil
+}
+
+func (l *local) Put(ctx context.Context, path string, r io.Reader, size int64, sum string) error {
+	destination := filepath.Join(l.root, filepath.FromSlash(path))
+	if err := utils.CreateDirectoryIfNotExists(filepath.Dir(destination)); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(destination), "."+filepath.Base(destination)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	writer := newCheckedWriter(tmp)
+	if _, err := io.Copy(writer, r); err != nil {
+		return err
+	}
+	if err := writer.verify(path, sum); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+
+	// Read back what reached the disk
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	stored, err := readSum(tmp)
+	if err != nil {
+		return err
+	}
+	if err := verify(path, stored, sum); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), destination)
+}
+
+func (l *local) Get(ctx context.Context, path string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(l.root, filepath.FromSlash(path)))
+}
+
+func (l *local) List(ctx context.Context) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(l.root, func(path string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return fs.SkipAll
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(l.root, path)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, filepath.ToSlash(rel))
+		return nil
+	})
+	return paths, err
+}
+
+func (l *local) Delete(ctx context.Context, path string) error {
+	return utils.RemoveFile(filepath.Join(l.root, filepath.FromSlash(path)))
+}
+
+func (l *local) Close() error {
+	return nil
+}
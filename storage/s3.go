@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/quix-labs/multipress/config"
+	"io"
+	"path"
+	"strings"
+)
+
+// partSize of multipart uploads, larger files being streamed by parts
+const partSize = 16 << 20
+
+// s3 stores backups in a bucket of AWS S3 or a compatible storage (MinIO, Garage, Ceph...)
+type s3 struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func newS3(cfg config.BackupTargetConfig) (*s3, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("bucket of s3 backup target " + cfg.Name + " is required")
+	}
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = "s3.amazonaws.com"
+	}
+
+	// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are used when keys are not configured
+	creds := credentials.NewEnvAWS()
+	if cfg.AccessKey != "" {
+		creds = credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, "")
+	}
+	lookup := minio.BucketLookupAuto
+	if cfg.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:        creds,
+		Secure:       !cfg.Insecure,
+		Region:       cfg.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := client.BucketExists(context.Background(), cfg.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach bucket %s of backup target %s: %w", cfg.Bucket, cfg.Name, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("bucket %s of backup target %s does not exist", cfg.Bucket, cfg.Name)
+	}
+	return &s3{client: client, bucket: cfg.Bucket, prefix: strings.Trim(cfg.Path, "/")}, nil
+}
+
+func (s *s3) key(p string) string {
+	return path.Join(s.prefix, p)
+}
+
+// Put uploads by parts, each one being checked by the server against its MD5
+func (s *s3) Put(ctx context.Context, p string, r io.Reader, size int64, sum string) error {
+	hasher := sha256.New()
+	info, err := s.client.PutObject(ctx, s.bucket, s.key(p), io.TeeReader(r, hasher), size, minio.PutObjectOptions{
+		PartSize:       partSize,
+		SendContentMd5: true,
+		UserMetadata:   map[string]string{"sha256": sum},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := verify(p, hex.EncodeToString(hasher.Sum(nil)), sum); err != nil {
+		return errors.Join(err, s.Delete(ctx, p))
+	}
+	if info.Size != size {
+		return errors.Join(fmt.Errorf("size mismatch of %s: stored %d, expected %d", p, info.Size, size), s.Delete(ctx, p))
+	}
+	return nil
+}
+
+func (s *s3) Get(ctx context.Context, p string) (io.ReadCloser, error) {
+	return s.client.GetObject(ctx, s.bucket, s.key(p), minio.GetObjectOptions{})
+}
+
+func (s *s3) List(ctx context.Context) ([]string, error) {
+	prefix := ""
+	if s.prefix != "" {
+		prefix = s.prefix + "/"
+	}
+
+	var paths []string
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		paths = append(paths, strings.TrimPrefix(object.Key, prefix))
+	}
+	return paths, nil
+}
+
+func (s *s3) Delete(ctx context.Context, p string) error {
+	return s.client.RemoveObject(ctx, s.bucket, s.key(p), minio.RemoveObjectOptions{})
+}
+
+func (s *s3) Close() error {
+	return nil
+}
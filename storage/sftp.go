@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pkg/sftp"
+	"github.com/quix-labs/multipress/config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// sftpTarget copies backups to a directory of an SSH server, its host key being checked against known hosts
+type sftpTarget struct {
+	conn   *ssh.Client
+	client *sftp.Client
+	root   string
+}
+
+func newSftp(cfg config.BackupTargetConfig) (*sftpTarget, error) {
+	if cfg.Endpoint == "" || cfg.User == "" {
+		return nil, errors.New("endpoint and user of sftp backup target " + cfg.Name + " are required")
+	}
+	address := cfg.Endpoint
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "22")
+	}
+
+	var auth []ssh.AuthMethod
+	if cfg.KeyFile != "" {
+		key, err := os.ReadFile(cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key of backup target %s: %w", cfg.Name, err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key of backup target %s (keys protected by a passphrase are not supported): %w", cfg.Name, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if cfg.Password != "" {
+		auth = append(auth, ssh.Password(cfg.Password))
+	}
+
+	knownHostsPath := cfg.KnownHosts
+	if knownHostsPath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		knownHostsPath = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known hosts, add the server with 'ssh-keyscan %s >> %s': %w", cfg.Endpoint, knownHostsPath, err)
+	}
+
+	conn, err := ssh.Dial("tcp", address, &ssh.ClientConfig{
+		User:            cfg.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to backup target %s: %w", cfg.Name, err)
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start sftp on backup target %s: %w", cfg.Name, err)
+	}
+
+	root := cfg.Path
+	if root == "" {
+		root = "."
+	}
+	return &sftpTarget{conn: conn, client: client, root: root}, nil
+}
+
+func (s *sftpTarget) Put(ctx context.Context, p string, r io.Reader, size int64, sum string) error {
+	destination := path.Join(s.root, p)
+	if err := s.client.MkdirAll(path.Dir(destination)); err != nil {
+		return err
+	}
+	tmp := path.Join(path.Dir(destination), "."+path.Base(destination)+".tmp")
+	defer s.client.Remove(tmp)
+
+	file, err := s.client.Create(tmp)
+	if err != nil {
+		return err
+	}
+	writer := newCheckedWriter(file)
+	if _, err := io.Copy(writer, r); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := writer.verify(p, sum); err != nil {
+		return err
+	}
+
+	// Read back what reached the server
+	stored, err := s.client.Open(tmp)
+	if err != nil {
+		return err
+	}
+	storedSum, err := readSum(stored)
+	stored.Close()
+	if err != nil {
+		return err
+	}
+	if err := verify(p, storedSum, sum); err != nil {
+		return err
+	}
+
+	if err := s.client.PosixRename(tmp, destination); err != nil {
+		// Servers without the posix-rename extension do not replace files
+		_ = s.client.Remove(destination)
+		return s.client.Rename(tmp, destination)
+	}
+	return nil
+}
+
+func (s *sftpTarget) Get(ctx context.Context, p string) (io.ReadCloser, error) {
+	return s.client.Open(path.Join(s.root, p))
+}
+
+func (s *sftpTarget) List(ctx context.Context) ([]string, error) {
+	var paths []string
+	walker := s.client.Walk(s.root)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if os.IsNotExist(err) && walker.Path() == s.root {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if walker.Stat().IsDir() || strings.HasSuffix(walker.Path(), ".tmp") {
+			continue
+		}
+		rel := walker.Path()
+		if s.root != "." {
+			rel = strings.TrimPrefix(rel, strings.TrimSuffix(s.root, "/")+"/")
+		}
+		paths = append(paths, rel)
+	}
+	return paths, nil
+}
+
+func (s *sftpTarget) Delete(ctx context.Context, p string) error {
+	return s.client.Remove(path.Join(s.root, p))
+}
+
+func (s *sftpTarget) Close() error {
+	return errors.Join(s.client.Close(), s.conn.Close())
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/quix-labs/multipress/config"
+	"hash"
+	"io"
+)
+
+// Target stores files of backups elsewhere, paths being slash-separated and relative to the target
+type Target interface {
+	// Put streams r of size bytes to path, failing when the stored content does not match sum, its hex SHA-256
+	Put(ctx context.Context, path string, r io.Reader, size int64, sum string) error
+	Get(ctx context.Context, path string) (io.ReadCloser, error)
+	// List returns paths of all files, recursively
+	List(ctx context.Context) ([]string, error)
+	Delete(ctx context.Context, path string) error
+	Close() error
+}
+
+// Types of targets
+const (
+	TypeLocal = "local"
+	TypeS3    = "s3"
+	TypeSftp  = "sftp"
+)
+
+var Types = []string{TypeLocal, TypeS3, TypeSftp}
+
+// Open connects to the target
+func Open(cfg config.BackupTargetConfig) (Target, error) {
+	switch cfg.Type {
+	case TypeLocal:
+		return newLocal(cfg)
+	case TypeS3:
+		return newS3(cfg)
+	case TypeSftp:
+		return newSftp(cfg)
+	}
+	return nil, fmt.Errorf("unknown type %q of backup target %s, expected one of %v", cfg.Type, cfg.Name, Types)
+}
+
+// checkedWriter hashes written content, to verify a target received what was sent
+type checkedWriter struct {
+	io.Writer
+	hasher hash.Hash
+}
+
+func newCheckedWriter(w io.Writer) *checkedWriter {
+	hasher := sha256.New()
+	return &checkedWriter{Writer: io.MultiWriter(w, hasher), hasher: hasher}
+}
+
+func (w *checkedWriter) verify(path string, sum string) error {
+	return verify(path, hex.EncodeToString(w.hasher.Sum(nil)), sum)
+}
+
+// readSum hashes the content read back from a target
+func readSum(r io.Reader) (string, error) {
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func verify(path string, actual string, expected string) error {
+	if actual != expected {
+		return fmt.Errorf("checksum mismatch of %s: stored %s, expected %s", path, actual, expected)
+	}
+	return nil
+}
@@ -1,19 +1,33 @@
 package utils
 
 import (
+	"archive/tar"
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
 	"golang.org/x/sync/errgroup"
 	"io"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strings"
 )
 
+// ExecExitError is returned when an executed command exits with a non-zero code
+type ExecExitError struct {
+	ExitCode int
+}
+
+func (e ExecExitError) Error() string {
+	return fmt.Sprintf("command execution failed with exit code: %d", e.ExitCode)
+}
+
 func GetDockerClient() (*client.Client, error) {
 	return client.NewClientWithOpts(client.WithAPIVersionNegotiation())
 }
@@ -84,7 +98,7 @@ func ExecDockerCmd(containerName string, execOptions container.ExecOptions, inDa
 	}
 
 	if execInspectResp.ExitCode != 0 {
-		return output.String(), fmt.Errorf("command execution failed with exit code: %d", execInspectResp.ExitCode)
+		return output.String(), ExecExitError{ExitCode: execInspectResp.ExitCode}
 	}
 
 	return output.String(), nil
@@ -110,8 +124,42 @@ func GetDockerContainerIP(containerName string) (string, error) {
 	return "", fmt.Errorf("unable to retrieve IP address for container %s", containerName)
 }
 
+func CreateDockerNetworkIfNotExists(networkName string) error {
+	checkCmd := exec.Command("docker", "network", "ls", "--filter", fmt.Sprintf("name=%s", networkName), "--format", "{{.Name}}")
+	existingNetworks, err := checkCmd.Output()
+	if err != nil {
+		return errors.New("failed to check existing networks: " + err.Error())
+	}
+	if slices.Contains(strings.Fields(string(existingNetworks)), networkName) {
+		return SkippedError{Msg: "Network already exists"}
+	}
+
+	output, err := exec.Command("docker", "network", "create", networkName).CombinedOutput()
+	if err != nil {
+		return errors.Join(err, errors.New(string(output)))
+	}
+	return nil
+}
+
+func RemoveDockerNetworkIfExists(networkName string) error {
+	checkCmd := exec.Command("docker", "network", "ls", "--filter", fmt.Sprintf("name=%s", networkName), "--format", "{{.Name}}")
+	existingNetworks, err := checkCmd.Output()
+	if err != nil {
+		return errors.New("failed to check existing networks: " + err.Error())
+	}
+	if !slices.Contains(strings.Fields(string(existingNetworks)), networkName) {
+		return SkippedError{Msg: "Network does not exist"}
+	}
+
+	output, err := exec.Command("docker", "network", "rm", networkName).CombinedOutput()
+	if err != nil {
+		return errors.Join(err, errors.New(string(output)))
+	}
+	return nil
+}
+
 func UpComposeFile(composeFilePath string) (string, error) {
-	cmd := exec.Command("docker", "compose", "-f", composeFilePath, "up", "-d", "--wait")
+	cmd := exec.Command("docker", "compose", "-f", composeFilePath, "up", "-d", "--wait", "--remove-orphans")
 	cmd.Dir = filepath.Dir(composeFilePath)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
@@ -129,3 +177,184 @@ func DownComposeFile(composeFilePath string) (string, error) {
 	}
 	return string(output), nil
 }
+func BuildComposeFile(composeFilePath string) (string, error) {
+	cmd := exec.Command("docker", "compose", "-f", composeFilePath, "build")
+	cmd.Dir = filepath.Dir(composeFilePath)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(output), fmt.Errorf("failed to build %s: %w. Output: %s", composeFilePath, err, string(output))
+	}
+	return string(output), nil
+}
+
+func StreamDockerLogs(ctx context.Context, containerName string, logsOptions container.LogsOptions, stdout io.Writer, stderr io.Writer) error {
+	cli, err := GetDockerClient()
+	if err != nil {
+		return fmt.Errorf("error creating Docker client: %w", err)
+	}
+
+	containerJSON, err := cli.ContainerInspect(ctx, containerName)
+	if err != nil {
+		return fmt.Errorf("error inspecting container: %w", err)
+	}
+
+	reader, err := cli.ContainerLogs(ctx, containerName, logsOptions)
+	if err != nil {
+		return fmt.Errorf("error reading logs of %s: %w", containerName, err)
+	}
+	defer reader.Close()
+
+	// Output of TTY containers is not multiplexed
+	if containerJSON.Config.Tty {
+		_, err = io.Copy(stdout, reader)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, reader)
+	}
+	if err != nil && !errors.Is(err, context.Canceled) {
+		return fmt.Errorf("error streaming logs of %s: %w", containerName, err)
+	}
+	return nil
+}
+
+// DockerContainerState returns whether the container is running, and its health status if it has a healthcheck
+func DockerContainerState(containerName string) (bool, string, error) {
+	cli, err := GetDockerClient()
+	if err != nil {
+		return false, "", fmt.Errorf("error creating Docker client: %w", err)
+	}
+
+	containerJSON, err := cli.ContainerInspect(context.Background(), containerName)
+	if err != nil {
+		return false, "", fmt.Errorf("error inspecting container: %w", err)
+	}
+
+	health := ""
+	if containerJSON.State.Health != nil {
+		health = containerJSON.State.Health.Status
+	}
+	return containerJSON.State.Running, health, nil
+}
+
+func StartDockerContainer(containerName string) error {
+	cli, err := GetDockerClient()
+	if err != nil {
+		return fmt.Errorf("error creating Docker client: %w", err)
+	}
+	if err := cli.ContainerStart(context.Background(), containerName, container.StartOptions{}); err != nil {
+		return fmt.Errorf("error starting container %s: %w", containerName, err)
+	}
+	return nil
+}
+
+func StopDockerContainer(containerName string) error {
+	cli, err := GetDockerClient()
+	if err != nil {
+		return fmt.Errorf("error creating Docker client: %w", err)
+	}
+	if err := cli.ContainerStop(context.Background(), containerName, container.StopOptions{}); err != nil {
+		return fmt.Errorf("error stopping container %s: %w", containerName, err)
+	}
+	return nil
+}
+
+// GetDockerContainerRxBytes returns the total bytes received by the container on all its networks
+func GetDockerContainerRxBytes(containerName string) (uint64, error) {
+	cli, err := GetDockerClient()
+	if err != nil {
+		return 0, fmt.Errorf("error creating Docker client: %w", err)
+	}
+
+	stats, err := cli.ContainerStatsOneShot(context.Background(), containerName)
+	if err != nil {
+		return 0, fmt.Errorf("error reading stats of %s: %w", containerName, err)
+	}
+	defer stats.Body.Close()
+
+	var statsJSON container.StatsResponse
+	if err := json.NewDecoder(stats.Body).Decode(&statsJSON); err != nil {
+		return 0, fmt.Errorf("error decoding stats of %s: %w", containerName, err)
+	}
+
+	var rxBytes uint64
+	for _, network := range statsJSON.Networks {
+		rxBytes += network.RxBytes
+	}
+	return rxBytes, nil
+}
+
+// DockerContainerImage returns the image reference a container was created from, and the ID of that image
+func DockerContainerImage(containerName string) (string, string, error) {
+	cli, err := GetDockerClient()
+	if err != nil {
+		return "", "", fmt.Errorf("error creating Docker client: %w", err)
+	}
+
+	containerJSON, err := cli.ContainerInspect(context.Background(), containerName)
+	if err != nil {
+		return "", "", fmt.Errorf("error inspecting container: %w", err)
+	}
+	return containerJSON.Config.Image, containerJSON.Image, nil
+}
+
+// DockerImageRepoDigests returns the registry digests ("repository@sha256:...") of a local image, given by reference or ID
+func DockerImageRepoDigests(image string) ([]string, error) {
+	cli, err := GetDockerClient()
+	if err != nil {
+		return nil, fmt.Errorf("error creating Docker client: %w", err)
+	}
+
+	imageJSON, _, err := cli.ImageInspectWithRaw(context.Background(), image)
+	if err != nil {
+		return nil, fmt.Errorf("error inspecting image %s: %w", image, err)
+	}
+	return imageJSON.RepoDigests, nil
+}
+
+// ReadDockerContainerFile returns the content of a regular file inside a container, running or not
+func ReadDockerContainerFile(containerName string, path string) ([]byte, error) {
+	cli, err := GetDockerClient()
+	if err != nil {
+		return nil, fmt.Errorf("error creating Docker client: %w", err)
+	}
+
+	reader, _, err := cli.CopyFromContainer(context.Background(), containerName, path)
+	if err != nil {
+		return nil, fmt.Errorf("error copying %s from container: %w", path, err)
+	}
+	defer reader.Close()
+
+	// Content is sent as a tar archive holding the single file
+	tarReader := tar.NewReader(reader)
+	header, err := tarReader.Next()
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s from container: %w", path, err)
+	}
+	if header.Typeflag != tar.TypeReg {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+	return io.ReadAll(tarReader)
+}
+
+// DockerPortPublishers returns names of running containers publishing each host port
+func DockerPortPublishers() (map[uint16][]string, error) {
+	cli, err := GetDockerClient()
+	if err != nil {
+		return nil, fmt.Errorf("error creating Docker client: %w", err)
+	}
+
+	containers, err := cli.ContainerList(context.Background(), container.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing containers: %w", err)
+	}
+
+	publishers := make(map[uint16][]string)
+	for _, c := range containers {
+		name := strings.TrimPrefix(c.Names[0], "/")
+		for _, port := range c.Ports {
+			if port.PublicPort != 0 && !slices.Contains(publishers[port.PublicPort], name) {
+				publishers[port.PublicPort] = append(publishers[port.PublicPort], name)
+			}
+		}
+	}
+	return publishers, nil
+}
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// durationUnits prefixes weeks and days to units of time.ParseDuration
+var durationUnits = regexp.MustCompile(`^(?:(\d+)w)?(?:(\d+)d)?(.*)$`)
+
+// ParseDuration extends time.ParseDuration with days (d) and weeks (w) units, combined with others, e.g. "14d", "2w" or "1d12h".
+// Durations must be positive.
+func ParseDuration(s string) (time.Duration, error) {
+	matches := durationUnits.FindStringSubmatch(s)
+	if s == "" || matches == nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+
+	var duration time.Duration
+	for i, unit := range []time.Duration{7 * 24 * time.Hour, 24 * time.Hour} {
+		if matches[i+1] == "" {
+			continue
+		}
+		count, err := strconv.Atoi(matches[i+1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		duration += time.Duration(count) * unit
+	}
+	if rest := matches[3]; rest != "" {
+		parsed, err := time.ParseDuration(rest)
+		if err != nil || parsed < 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		duration += parsed
+	}
+
+	if duration <= 0 {
+		return 0, fmt.Errorf("duration %q must be positive", s)
+	}
+	return duration, nil
+}
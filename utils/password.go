@@ -2,6 +2,8 @@ package utils
 
 import (
 	"crypto/rand"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
 	"math/big"
 	mrand "math/rand"
 )
@@ -21,3 +23,17 @@ func GenerateSecurePassword(length int) string {
 
 	return string(password)
 }
+
+// HashPassword returns the bcrypt hash of password, as expected by Caddy basic_auth
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// PasswordMatchesHash reports whether hash was generated from password
+func PasswordMatchesHash(password string, hash string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// tcpListenState is the LISTEN state in /proc/net/tcp
+const tcpListenState = "0A"
+
+// ListeningTCPPorts returns TCP ports listened on the host (IPv4 and IPv6), readable without privileges
+func ListeningTCPPorts() (map[uint16]bool, error) {
+	ports := make(map[uint16]bool)
+	for _, path := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(file)
+		scanner.Scan() // Header
+		for scanner.Scan() {
+			// sl local_address rem_address st ..., with local_address as HEXIP:HEXPORT
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 4 || fields[3] != tcpListenState {
+				continue
+			}
+			_, hexPort, _ := strings.Cut(fields[1], ":")
+			if port, err := strconv.ParseUint(hexPort, 16, 16); err == nil {
+				ports[uint16(port)] = true
+			}
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return ports, nil
+}
@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+	"golang.org/x/term"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// InteractiveDockerCmd runs a command attached to the current terminal, in raw mode when stdin is a TTY
+func InteractiveDockerCmd(containerName string, execOptions container.ExecOptions) error {
+	cli, err := GetDockerClient()
+	if err != nil {
+		return fmt.Errorf("error creating Docker client: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	containerJSON, err := cli.ContainerInspect(ctx, containerName)
+	if err != nil {
+		return fmt.Errorf("error inspecting container: %w", err)
+	}
+	if !containerJSON.State.Running {
+		return fmt.Errorf("container %s is not running", containerName)
+	}
+
+	stdinFd, stdoutFd := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	tty := term.IsTerminal(stdinFd) && term.IsTerminal(stdoutFd)
+
+	execOptions.AttachStdin = true
+	execOptions.AttachStdout = true
+	execOptions.AttachStderr = true
+	execOptions.Tty = tty
+	if tty {
+		if width, height, err := term.GetSize(stdoutFd); err == nil {
+			execOptions.ConsoleSize = &[2]uint{uint(height), uint(width)}
+		}
+		execOptions.Env = append(execOptions.Env, "TERM="+os.Getenv("TERM"))
+	}
+
+	execIDResp, err := cli.ContainerExecCreate(ctx, containerName, execOptions)
+	if err != nil {
+		return fmt.Errorf("error creating exec instance: %w", err)
+	}
+
+	attachResp, err := cli.ContainerExecAttach(ctx, execIDResp.ID, container.ExecAttachOptions{
+		Tty:         tty,
+		ConsoleSize: execOptions.ConsoleSize,
+	})
+	if err != nil {
+		return fmt.Errorf("error attaching to exec instance: %w", err)
+	}
+	defer attachResp.Close()
+
+	if tty {
+		oldState, err := term.MakeRaw(stdinFd)
+		if err != nil {
+			return fmt.Errorf("error setting terminal in raw mode: %w", err)
+		}
+		defer term.Restore(stdinFd, oldState)
+
+		// Forward terminal resizes to the exec instance
+		resize := make(chan os.Signal, 1)
+		signal.Notify(resize, syscall.SIGWINCH)
+		defer signal.Stop(resize)
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-resize:
+					if width, height, err := term.GetSize(stdoutFd); err == nil {
+						_ = cli.ContainerExecResize(ctx, execIDResp.ID, container.ResizeOptions{Height: uint(height), Width: uint(width)})
+					}
+				}
+			}
+		}()
+	}
+
+	// Stdin copy is not awaited: it blocks on read until the next key press
+	go func() {
+		_, _ = io.Copy(attachResp.Conn, os.Stdin)
+		_ = attachResp.CloseWrite()
+	}()
+
+	if tty {
+		_, err = io.Copy(os.Stdout, attachResp.Reader)
+	} else {
+		_, err = stdcopy.StdCopy(os.Stdout, os.Stderr, attachResp.Reader)
+	}
+	if err != nil {
+		return fmt.Errorf("error reading exec output: %w", err)
+	}
+
+	execInspectResp, err := cli.ContainerExecInspect(ctx, execIDResp.ID)
+	if err != nil {
+		return fmt.Errorf("error inspecting exec instance: %w", err)
+	}
+	if execInspectResp.ExitCode != 0 {
+		return ExecExitError{ExitCode: execInspectResp.ExitCode}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// PrefixWriter writes every complete line prefixed, sharing a lock with others writers of the same output
+type PrefixWriter struct {
+	out    io.Writer
+	lock   *sync.Mutex
+	prefix []byte
+	buffer bytes.Buffer
+}
+
+func NewPrefixWriter(out io.Writer, lock *sync.Mutex, prefix string) *PrefixWriter {
+	return &PrefixWriter{out: out, lock: lock, prefix: []byte(prefix)}
+}
+
+func (w *PrefixWriter) Write(p []byte) (int, error) {
+	w.buffer.Write(p)
+	for {
+		line, err := w.buffer.ReadBytes('\n')
+		if err != nil {
+			// Incomplete line, keep it for the next write
+			w.buffer.Reset()
+			w.buffer.Write(line)
+			return len(p), nil
+		}
+		if err := w.writeLine(line); err != nil {
+			return len(p), err
+		}
+	}
+}
+
+// Flush writes the remaining incomplete line, if any
+func (w *PrefixWriter) Flush() error {
+	if w.buffer.Len() == 0 {
+		return nil
+	}
+	line := append(w.buffer.Bytes(), '\n')
+	w.buffer.Reset()
+	return w.writeLine(line)
+}
+
+func (w *PrefixWriter) writeLine(line []byte) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	_, err := w.out.Write(append(append([]byte{}, w.prefix...), line...))
+	return err
+}